@@ -0,0 +1,246 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"k8sctl/backup"
+	"k8sctl/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	k8scrdClient "github.com/changqings/k8scrd/client"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Record is one operation in audit.jsonl
+type Record struct {
+	Time      time.Time          `json:"time"`
+	Operation string             `json:"operation"`
+	Args      []string           `json:"args"`
+	User      string             `json:"user"`
+	Context   string             `json:"context"`
+	Objects   []backup.ObjectRef `json:"objects,omitempty"`
+	// backup ids of the objects before and after the operation
+	Before     []string `json:"before,omitempty"`
+	After      []string `json:"after,omitempty"`
+	Result     string   `json:"result"`
+	Error      string   `json:"error,omitempty"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+// Op is an operation in progress
+type Op struct {
+	record Record
+	start  time.Time
+}
+
+// Start begin the record of operation, objects is what the command is about, more are added from its backups
+func Start(operation string, args []string, objects ...backup.ObjectRef) *Op {
+	now := time.Now()
+	return &Op{
+		start: now,
+		record: Record{
+			Time:      now,
+			Operation: operation,
+			Args:      args,
+			User:      utils.CurrentUser(),
+			Context:   utils.CurrentContext(),
+			Objects:   objects,
+		},
+	}
+}
+
+// Finish write the record with the result of err, the backups taken by the operation and an after backup of them.
+// err is returned as is, a failed audit only log
+func (o *Op) Finish(err error) error {
+	r := &o.record
+	r.Result = ResultOK
+	if err != nil {
+		r.Result = ResultError
+		r.Error = err.Error()
+	}
+
+	before := backup.Taken()
+	for _, e := range before {
+		r.Before = append(r.Before, e.ID)
+		for _, obj := range e.Objects {
+			r.addObject(obj)
+		}
+	}
+	if len(before) > 0 {
+		after, aerr := afterBackups(r.Operation, before)
+		if aerr != nil {
+			log.Printf("Backup after %s err: %v", r.Operation, aerr)
+		}
+		for _, e := range after {
+			r.After = append(r.After, e.ID)
+		}
+	}
+	r.DurationMs = time.Since(o.start).Milliseconds()
+
+	if werr := write(r); werr != nil {
+		log.Printf("Write audit record of %s err: %v", r.Operation, werr)
+	}
+	return err
+}
+
+func afterBackups(operation string, before []backup.Entry) ([]backup.Entry, error) {
+	client, err := k8scrdClient.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(client.RestConfig)
+	if err != nil {
+		return nil, err
+	}
+	return backup.AfterMutation(client.KubeClient, dc, operation, before)
+}
+
+func (r *Record) addObject(obj backup.ObjectRef) {
+	for _, o := range r.Objects {
+		if o == obj {
+			return
+		}
+	}
+	r.Objects = append(r.Objects, obj)
+}
+
+func write(r *Record) error {
+	s, err := backup.Open()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.Append(backup.AuditFile, append(line, '\n'))
+}
+
+// List return every record, oldest first, the records of the legacy flat storage come first
+func List() ([]Record, error) {
+	legacy, err := backup.OpenLegacy()
+	if err != nil {
+		return nil, err
+	}
+	s, err := backup.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, st := range []backup.Storage{legacy, s} {
+		if st == nil {
+			continue
+		}
+		d, err := backup.ReadAppended(st, backup.AuditFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d...)
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Printf("Skip broken audit line: %v", err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+type Query struct {
+	// name, namespace/name, kind/name or kind/namespace/name
+	Object string
+	User   string
+	Since  time.Time
+}
+
+// Search return the records match q
+func Search(q Query) ([]Record, error) {
+	records, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Record
+	for _, r := range records {
+		if !q.Since.IsZero() && r.Time.Before(q.Since) {
+			continue
+		}
+		if q.User != "" && r.User != q.User {
+			continue
+		}
+		if q.Object != "" && !r.hasObject(q.Object) {
+			continue
+		}
+		found = append(found, r)
+	}
+	return found, nil
+}
+
+func (r *Record) hasObject(q string) bool {
+	for _, o := range r.Objects {
+		for _, s := range []string{
+			o.Name,
+			o.Namespace + "/" + o.Name,
+			o.Kind + "/" + o.Name,
+			o.Kind + "/" + o.Namespace + "/" + o.Name,
+		} {
+			if strings.EqualFold(s, q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Export write records as jsonl
+func Export(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Post send records as jsonl to an http sink
+func Post(url string, records []Record) error {
+	var buf bytes.Buffer
+	if err := Export(&buf, records); err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/x-ndjson", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("post audit records to %s: %s %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
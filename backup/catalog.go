@@ -0,0 +1,290 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"k8sctl/utils"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	indexFile = "index.jsonl"
+	// file name time layout, one file per second at most, same second files get a -N suffix
+	timeLayout = "2006-01-02-15-04-05"
+)
+
+type ObjectRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Entry is one backup in index.jsonl
+type Entry struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	User      string    `json:"user"`
+	Context   string    `json:"context"`
+	// api server of the cluster
+	Cluster   string      `json:"cluster,omitempty"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Objects   []ObjectRef `json:"objects"`
+	File      string      `json:"file"`
+	Checksum  string      `json:"checksum"`
+	// found in the flat storage of an older k8sctl, see OpenLegacy
+	legacy bool
+}
+
+// Save write data as a yaml backup file and record it in the index
+func Save(operation, ns, name string, data []byte, objects []ObjectRef) (*Entry, error) {
+	return SaveFile(operation, ns, name, ".yaml", data, objects)
+}
+
+// SaveFile is Save with the file extension, e.g. .tar.gz
+func SaveFile(operation, ns, name, ext string, data []byte, objects []ObjectRef) (*Entry, error) {
+	s, err := Open()
+	if err != nil {
+		return nil, err
+	}
+
+	_, server := utils.CurrentCluster()
+	sum := sha256.Sum256(data)
+	e := &Entry{
+		Time:      time.Now(),
+		Operation: operation,
+		User:      utils.CurrentUser(),
+		Context:   utils.CurrentContext(),
+		Cluster:   server,
+		Namespace: ns,
+		Name:      name,
+		Objects:   objects,
+		Checksum:  hex.EncodeToString(sum[:]),
+	}
+	if err := store(s, e, ext, data); err != nil {
+		return nil, err
+	}
+	autoPrune()
+	return e, nil
+}
+
+// store pick a free id for e and write data under the index lock, so two saves in the same second
+// never take the same file, then add e to the index
+func store(s Storage, e *Entry, ext string, data []byte) error {
+	unlock, err := s.Lock(indexFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	id := fmt.Sprintf("%s-%s-%s", e.Namespace, e.Name, e.Time.Format(timeLayout))
+	for i := 1; ; i++ {
+		if _, err := s.Size(id + ext); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return err
+		}
+		id = fmt.Sprintf("%s-%s-%s-%d", e.Namespace, e.Name, e.Time.Format(timeLayout), i)
+	}
+	e.ID, e.File = id, id+ext
+
+	if err := s.Put(e.File, data); err != nil {
+		log.Printf("Write file %s err: %v\n", s.Location(e.File), err)
+		return err
+	}
+	return appendLine(s, e)
+}
+
+// appendIndex add e to the index under the index lock, so a concurrent prune not drop it
+func appendIndex(s Storage, e *Entry) error {
+	unlock, err := s.Lock(indexFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return appendLine(s, e)
+}
+
+// appendLine add e to the index, the caller hold the index lock
+func appendLine(s Storage, e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.Append(indexFile, append(line, '\n'))
+}
+
+// List return every entry of the index, oldest first, the backups of the legacy flat storage come first
+func List() ([]Entry, error) {
+	legacy, err := OpenLegacy()
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if legacy != nil {
+		if entries, _, err = readIndex(legacy); err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			entries[i].legacy = true
+		}
+	}
+
+	s, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	current, _, err := readIndex(s)
+	return append(entries, current...), err
+}
+
+// storageOf return the storage keep the file of e
+func storageOf(e *Entry) (Storage, error) {
+	if e.legacy {
+		return openAt("")
+	}
+	return Open()
+}
+
+// readIndex return the entries and the appended parts of the index read
+func readIndex(s Storage) ([]Entry, []string, error) {
+	data, parts, err := readAppended(s, indexFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Skip broken index line: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, parts, scanner.Err()
+}
+
+// Show return the entry and content of a backup, verified by checksum
+func Show(id string) (*Entry, []byte, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		s, err := storageOf(&e)
+		if err != nil {
+			return &e, nil, err
+		}
+		data, err := s.Get(e.File)
+		if err != nil {
+			return &e, nil, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != e.Checksum {
+			return &e, data, fmt.Errorf("backup %s checksum mismatch, file was changed", id)
+		}
+		return &e, data, nil
+	}
+	return nil, nil, fmt.Errorf("backup %s not found", id)
+}
+
+// Path is the full path or url of the backup file
+func Path(e *Entry) string {
+	s, err := storageOf(e)
+	if err != nil {
+		return e.File
+	}
+	return s.Location(e.File)
+}
+
+type Query struct {
+	// substring of the object name
+	Name      string
+	Namespace string
+	Operation string
+	Since     time.Time
+}
+
+// Search return the entries match q, a backup match name when any of its objects match
+func Search(q Query) ([]Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Entry
+	for _, e := range entries {
+		if !q.Since.IsZero() && e.Time.Before(q.Since) {
+			continue
+		}
+		if q.Operation != "" && e.Operation != q.Operation {
+			continue
+		}
+		if q.Namespace != "" && !e.inNamespace(q.Namespace) {
+			continue
+		}
+		if q.Name != "" && !e.hasName(q.Name) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found, nil
+}
+
+func (e *Entry) inNamespace(ns string) bool {
+	if e.Namespace == ns {
+		return true
+	}
+	for _, o := range e.Objects {
+		if o.Namespace == ns {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Entry) hasName(name string) bool {
+	if strings.Contains(e.Name, name) {
+		return true
+	}
+	for _, o := range e.Objects {
+		if strings.Contains(o.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSince accept a duration like 24h, or a date like 2006-01-02
+func ParseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("--since = %s is not a duration like 24h or a date like 2006-01-02", s)
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupState point the k8sctl config, the state dir and kubeconfig into a temp dir
+func setupState(t *testing.T, storageDir string) string {
+	home := t.TempDir()
+	kubeconfig := filepath.Join(home, "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: admin@prod
+  context:
+    cluster: prod
+    user: admin
+current-context: admin@prod
+users:
+- name: admin
+  user: {}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(home, "k8sctl.yaml")
+	data := "backup:\n  storage:\n    dir: " + storageDir + "\n"
+	if storageDir == "" {
+		data = ""
+	}
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", kubeconfig)
+	t.Setenv("K8SCTL_CONFIG", config)
+	t.Setenv("K8SCTL_HOME", "")
+	return home
+}
+
+func TestOpenDir(t *testing.T) {
+	home := setupState(t, "")
+	storageDir := filepath.Join(home, "storage")
+	stateDir := filepath.Join(home, "state")
+
+	tests := []struct {
+		name       string
+		storageDir string
+		stateDir   string
+		want       string
+	}{
+		{name: "storage dir", storageDir: storageDir, want: storageDir},
+		{name: "state dir over storage dir", storageDir: storageDir, stateDir: stateDir, want: stateDir},
+		{name: "state dir", stateDir: stateDir, want: stateDir},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupState(t, tt.storageDir)
+			t.Setenv("K8SCTL_HOME", tt.stateDir)
+			s, err := Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := filepath.Join(tt.want, "prod", "admin_prod")
+			if got := s.(*Local).Dir; got != want {
+				t.Errorf("Open dir %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestListLegacy(t *testing.T) {
+	home := setupState(t, "")
+	t.Setenv("K8SCTL_HOME", home)
+
+	// flat layout of an older k8sctl, without cluster
+	legacy := &Local{Dir: home}
+	old := &Entry{ID: "dev-api-old", Namespace: "dev", Name: "api", File: "dev-api-old.yaml"}
+	if err := legacy.Put(old.File, []byte("kind: Deployment\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendIndex(legacy, old); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Save("update deployment labels", "dev", "api", []byte("kind: Deployment\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != old.ID || entries[1].ID != e.ID {
+		t.Fatalf("List got %v, want the legacy backup then the new one", entries)
+	}
+	if p := Path(&entries[0]); p != filepath.Join(home, old.File) {
+		t.Errorf("legacy backup path %s, want %s", p, filepath.Join(home, old.File))
+	}
+	if p := Path(&entries[1]); !strings.HasPrefix(p, filepath.Join(home, "prod", "admin_prod")) {
+		t.Errorf("new backup path %s, want under the cluster dir", p)
+	}
+	if _, data, err := Show(e.ID); err != nil || string(data) != "kind: Deployment\n" {
+		t.Errorf("Show %s got %q, %v", e.ID, data, err)
+	}
+}
+
+func TestStoreSameSecond(t *testing.T) {
+	s := &Local{Dir: t.TempDir()}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := &Entry{Time: now, Namespace: "dev", Name: "api"}
+			if err := store(s, e, ".yaml", []byte("kind: Deployment\n")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _, err := readIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		files[e.File] = true
+	}
+	if len(entries) != 10 || len(files) != 10 {
+		t.Errorf("got %d entries in %d files, want 10 in 10", len(entries), len(files))
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	setupState(t, "")
+	tests := []struct {
+		name    string
+		cluster string
+		force   bool
+		wantErr bool
+	}{
+		{name: "same cluster", cluster: "https://prod.example.com"},
+		{name: "other cluster", cluster: "https://dev.example.com", wantErr: true},
+		{name: "other cluster forced", cluster: "https://dev.example.com", force: true},
+		{name: "no header", wantErr: true},
+		{name: "no header forced", force: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCluster(tt.cluster, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckCluster(%q, %v) err = %v, want err %v", tt.cluster, tt.force, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"fmt"
+	"k8sctl/manifest"
+	"k8sctl/utils"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// taken is every backup written by BeforeMutation in this run, for the audit record
+var taken []Entry
+
+// BeforeMutation back up objs right before operation change or delete them, every mutating command go through it.
+// typed objects from clientset and *unstructured.Unstructured are both accepted, secrets are protected by ProtectSecrets.
+// nothing is written when objs is empty
+func BeforeMutation(operation string, objs ...runtime.Object) (*Entry, error) {
+	if len(objs) == 0 {
+		return nil, nil
+	}
+
+	var maps []map[string]any
+	for _, obj := range objs {
+		m, err := toMap(obj)
+		if err != nil {
+			return nil, err
+		}
+		maps = append(maps, m)
+	}
+
+	e, err := saveObjects(operation, maps)
+	if err != nil {
+		log.Printf("Backup before %s err: %v", operation, err)
+		return nil, err
+	}
+	log.Printf("Backup before %s, id = %s, file = %s", operation, e.ID, Path(e))
+	taken = append(taken, *e)
+	return e, nil
+}
+
+// Taken return the backups written by BeforeMutation so far
+func Taken() []Entry {
+	return taken
+}
+
+// AfterMutation back up the live objects of each before backup once operation is done,
+// so both sides of a change are kept. objects deleted by operation are left out
+func AfterMutation(cs *kubernetes.Clientset, dc dynamic.Interface, operation string, before []Entry) ([]Entry, error) {
+	var entries []Entry
+	for _, b := range before {
+		_, objs, err := Load(b.ID)
+		if err != nil {
+			return entries, err
+		}
+		live, err := manifest.FetchLive(cs, dc, objs)
+		if err != nil {
+			return entries, err
+		}
+		if len(live) == 0 {
+			continue
+		}
+		e, err := saveObjects("after "+operation, live)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+// saveObjects write objs as one multi-document yaml backup
+func saveObjects(operation string, objs []map[string]any) (*Entry, error) {
+	var refs []ObjectRef
+	for _, m := range objs {
+		if meta, ok := m["metadata"].(map[string]any); ok {
+			delete(meta, "managedFields")
+		}
+		refs = append(refs, objectRef(m))
+	}
+	if err := ProtectSecrets(objs, false); err != nil {
+		return nil, err
+	}
+
+	_, server := utils.CurrentCluster()
+	data := []byte(fmt.Sprintf("%s%s\n%s%s\n", clusterHeader, server, contextHeader, utils.CurrentContext()))
+	for i, m := range objs {
+		doc, err := yaml.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			data = append(data, "---\n"...)
+		}
+		data = append(data, doc...)
+	}
+	return Save(operation, refs[0].Namespace, refs[0].Name, data, refs)
+}
+
+// toMap convert obj to unstructured with apiVersion and kind, objects from clientset have none
+func toMap(obj runtime.Object) (map[string]any, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.DeepCopy().Object, nil
+	}
+
+	gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(gvks) == 0 {
+		return nil, fmt.Errorf("unknown kind of %T", obj)
+	}
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"k8sctl/config"
+	"log"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// AuditFile is the audit log kept next to index.jsonl, backups its records point to are never pruned
+const AuditFile = "audit.jsonl"
+
+// Prune delete the backups not kept by the retention policy of k8sctl config,
+// the latest backup of every object and the backups referenced by audit records are never deleted.
+// return the pruned entries
+func Prune(dryRun bool) ([]Entry, error) {
+	c, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	policy := c.Backup.Retention
+
+	var maxSize int64
+	if policy.MaxTotalSize != "" {
+		q, err := resource.ParseQuantity(policy.MaxTotalSize)
+		if err != nil {
+			return nil, err
+		}
+		maxSize = q.Value()
+	}
+	if policy.KeepLast <= 0 && policy.KeepDays <= 0 && maxSize <= 0 {
+		return nil, nil
+	}
+
+	s, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	// a backup saved by another run while the index is rewritten must not be lost
+	unlock, err := s.Lock(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, parts, err := readIndex(s)
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := auditReferenced(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	if maxSize > 0 {
+		for _, e := range entries {
+			if size, err := s.Size(e.File); err == nil {
+				sizes[e.ID] = size
+			}
+		}
+	}
+
+	prune, protected := selectPrune(entries, policy, maxSize, sizes, referenced, time.Now())
+	if protected > 0 {
+		log.Printf("Kept %d backups out of retention policy, audit records reference them", protected)
+	}
+
+	var pruned, kept []Entry
+	for _, e := range entries {
+		if prune[e.ID] {
+			pruned = append(pruned, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	for _, e := range pruned {
+		if err := s.Delete(e.File); err != nil {
+			log.Printf("Remove backup file %s err: %v", s.Location(e.File), err)
+		}
+	}
+	return pruned, writeIndex(s, kept, parts)
+}
+
+// selectPrune return the ids of entries the policy not keep, and how many of them are protected by referenced.
+// keepLast and keepDays are applied per object, then the oldest are dropped until the total size fit maxSize
+func selectPrune(entries []Entry, policy config.Retention, maxSize int64, sizes map[string]int64,
+	referenced map[string]bool, now time.Time) (map[string]bool, int) {
+	byTime := append([]Entry(nil), entries...)
+	// newest first
+	sort.SliceStable(byTime, func(i, j int) bool { return byTime[i].Time.After(byTime[j].Time) })
+
+	latest := make(map[string]bool)
+	seen := make(map[string]int)
+	prune := make(map[string]bool)
+	protected := 0
+	drop := func(id string) {
+		if referenced[id] {
+			protected++
+			return
+		}
+		prune[id] = true
+	}
+
+	for _, e := range byTime {
+		// keepLast count per object, an entry is kept while it is recent enough for any of its objects
+		isLatest, inLast := false, false
+		for _, key := range objectKeys(e) {
+			seen[key]++
+			if seen[key] == 1 {
+				isLatest = true
+			}
+			if seen[key] <= policy.KeepLast {
+				inLast = true
+			}
+		}
+		if isLatest {
+			latest[e.ID] = true
+			continue
+		}
+
+		keep := inLast
+		if policy.KeepDays > 0 && now.Sub(e.Time) < time.Duration(policy.KeepDays)*24*time.Hour {
+			keep = true
+		}
+		if !keep && (policy.KeepLast > 0 || policy.KeepDays > 0) {
+			drop(e.ID)
+		}
+	}
+
+	// drop the oldest until total size fit
+	if maxSize > 0 {
+		var total int64
+		for _, e := range byTime {
+			if !prune[e.ID] {
+				total += sizes[e.ID]
+			}
+		}
+		for i := len(byTime) - 1; i >= 0 && total > maxSize; i-- {
+			e := byTime[i]
+			if prune[e.ID] || latest[e.ID] || referenced[e.ID] {
+				continue
+			}
+			prune[e.ID] = true
+			total -= sizes[e.ID]
+		}
+		if total > maxSize {
+			log.Printf("Backups still use %d bytes after prune, more than maxTotalSize = %d, the latest backup of every object is kept", total, maxSize)
+		}
+	}
+	return prune, protected
+}
+
+// objectKeys is kind/namespace/name of every object in the backup, namespace/name for an entry without objects
+func objectKeys(e Entry) []string {
+	if len(e.Objects) == 0 {
+		return []string{"/" + e.Namespace + "/" + e.Name}
+	}
+	keys := make([]string, 0, len(e.Objects))
+	for _, o := range e.Objects {
+		keys = append(keys, o.Kind+"/"+o.Namespace+"/"+o.Name)
+	}
+	return keys
+}
+
+// auditReferenced return the ids of the before and after backups of every audit record
+func auditReferenced(s Storage) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	data, err := ReadAppended(s, AuditFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return referenced, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var r struct {
+			Before []string `json:"before"`
+			After  []string `json:"after"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		for _, id := range append(r.Before, r.After...) {
+			referenced[id] = true
+		}
+	}
+	return referenced, scanner.Err()
+}
+
+// autoPrune run after every backup, a failed prune not fail the backup
+func autoPrune() {
+	pruned, err := Prune(false)
+	if err != nil {
+		log.Printf("Prune backups err: %v", err)
+		return
+	}
+	if len(pruned) > 0 {
+		log.Printf("Pruned %d old backups by retention policy", len(pruned))
+	}
+}
+
+// writeIndex replace the index with entries, the appended parts already merged into entries are deleted.
+// the caller hold the index lock
+func writeIndex(s Storage, entries []Entry, parts []string) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := s.Put(indexFile, buf.Bytes()); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := s.Delete(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
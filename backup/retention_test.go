@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"k8sctl/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSelectPrune(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entry := func(id, name string, age time.Duration) Entry {
+		return Entry{ID: id, Namespace: "ns", Name: name, Time: now.Add(-age)}
+	}
+	objects := func(e Entry, refs ...ObjectRef) Entry {
+		e.Objects = refs
+		return e
+	}
+	entries := []Entry{
+		entry("a1", "a", 10*day),
+		entry("a2", "a", 5*day),
+		entry("a3", "a", 2*day),
+		entry("a4", "a", time.Hour),
+		entry("b1", "b", 30*day),
+	}
+	sizes := map[string]int64{"a1": 100, "a2": 100, "a3": 100, "a4": 100, "b1": 100}
+
+	tests := []struct {
+		name       string
+		entries    []Entry
+		policy     config.Retention
+		maxSize    int64
+		referenced map[string]bool
+		want       []string
+		protected  int
+	}{
+		{
+			name:   "keep last",
+			policy: config.Retention{KeepLast: 2},
+			want:   []string{"a1", "a2"},
+		},
+		{
+			name:   "keep days",
+			policy: config.Retention{KeepDays: 3},
+			want:   []string{"a1", "a2"},
+		},
+		{
+			name:   "keep last or days",
+			policy: config.Retention{KeepLast: 3, KeepDays: 3},
+			want:   []string{"a1"},
+		},
+		{
+			name:   "latest of every object kept",
+			policy: config.Retention{KeepLast: 1, KeepDays: 1},
+			want:   []string{"a1", "a2", "a3"},
+		},
+		{
+			name:    "max size drop oldest",
+			maxSize: 300,
+			want:    []string{"a1", "a2"},
+		},
+		{
+			name:    "max size keep latest",
+			maxSize: 100,
+			want:    []string{"a1", "a2", "a3"},
+		},
+		{
+			name:       "referenced by audit",
+			policy:     config.Retention{KeepLast: 1},
+			referenced: map[string]bool{"a2": true},
+			want:       []string{"a1", "a3"},
+			protected:  1,
+		},
+		{
+			name:       "referenced over max size",
+			maxSize:    200,
+			referenced: map[string]bool{"a1": true},
+			want:       []string{"a2", "a3"},
+		},
+		{
+			name: "service and deployment of one copy",
+			entries: []Entry{
+				objects(entry("svc1", "api", 2*day), ObjectRef{Kind: "Service", Namespace: "ns", Name: "api"}),
+				objects(entry("deploy1", "api", 2*day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"}),
+				objects(entry("deploy2", "api", day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"}),
+			},
+			policy: config.Retention{KeepLast: 1},
+			want:   []string{"deploy1"},
+		},
+		{
+			name: "latest of any object",
+			entries: []Entry{
+				objects(entry("both", "api", 2*day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"},
+					ObjectRef{Kind: "Service", Namespace: "ns", Name: "api"}),
+				objects(entry("deploy", "api", day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"}),
+			},
+			policy: config.Retention{KeepLast: 1},
+		},
+		{
+			name: "keep last of any object",
+			entries: []Entry{
+				objects(entry("old", "api", 3*day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"}),
+				objects(entry("both", "api", 2*day), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"},
+					ObjectRef{Kind: "Service", Namespace: "ns", Name: "api"}),
+				objects(entry("svc", "api", day), ObjectRef{Kind: "Service", Namespace: "ns", Name: "api"}),
+				objects(entry("deploy", "api", time.Hour), ObjectRef{Kind: "Deployment", Namespace: "ns", Name: "api"}),
+			},
+			policy: config.Retention{KeepLast: 2},
+			want:   []string{"old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := entries
+			if tt.entries != nil {
+				es = tt.entries
+			}
+			prune, protected := selectPrune(es, tt.policy, tt.maxSize, sizes, tt.referenced, now)
+			var got []string
+			for id := range prune {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("pruned %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("pruned %v, want %v", got, tt.want)
+				}
+			}
+			if protected != tt.protected {
+				t.Errorf("protected %d, want %d", protected, tt.protected)
+			}
+		})
+	}
+}
+
+func TestAuditReferenced(t *testing.T) {
+	s := newMemStorage()
+	_ = s.Append(AuditFile, []byte(`{"time":"2026-10-01T12:00:00Z","operation":"copy deployment","args":["copy","deployment"],`+
+		`"user":"ci","context":"admin@prod","objects":[{"kind":"Deployment","namespace":"pr-1","name":"api"}],`+
+		`"before":["b1"],"after":["b2"],"result":"success","durationMs":1200}`+"\n"))
+	_ = s.Append(AuditFile, []byte(`{"time":"2026-10-01T13:00:00Z","operation":"restore","args":["restore","b1"],`+
+		`"user":"ci","context":"admin@prod","before":["b3"],"result":"failed","error":"conflict","durationMs":300}`+"\n"))
+
+	got, err := auditReferenced(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b1", "b2", "b3"} {
+		if !got[id] {
+			t.Errorf("%s not referenced, got %v", id, got)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("referenced %v, want 3 ids", got)
+	}
+}
+
+func TestLocalLock(t *testing.T) {
+	l := &Local{Dir: t.TempDir()}
+	unlock, err := l.Lock(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taken := make(chan struct{})
+	go func() {
+		unlock, err := l.Lock(indexFile)
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlock()
+		}
+		close(taken)
+	}()
+	select {
+	case <-taken:
+		t.Fatal("lock taken twice")
+	case <-time.After(500 * time.Millisecond):
+	}
+	unlock()
+	<-taken
+
+	// a lock left by a killed run
+	p := filepath.Join(l.Dir, indexFile+".lock")
+	if err := os.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStale)
+	_ = os.Chtimes(p, old, old)
+	unlock, err = l.Lock(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
@@ -0,0 +1,344 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"k8sctl/config"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 is an S3-compatible bucket, requests are signed with AWS signature v4
+type S3 struct {
+	Endpoint     *url.URL
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	HTTP         *http.Client
+}
+
+func NewS3(c config.S3) (*S3, error) {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return nil, fmt.Errorf("backup.storage.s3 need endpoint and bucket")
+	}
+	endpoint := c.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &S3{
+		Endpoint:     u,
+		Region:       c.Region,
+		Bucket:       c.Bucket,
+		Prefix:       c.Prefix,
+		AccessKey:    c.AccessKeyID,
+		SecretKey:    c.SecretAccessKey,
+		SessionToken: c.SessionToken,
+		HTTP:         &http.Client{Timeout: 60 * time.Second},
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" {
+		s.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("backup.storage.s3 need accessKeyID and secretAccessKey, or env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s *S3) Put(name string, data []byte) error {
+	resp, err := s.do(http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check(resp, name)
+}
+
+func (s *S3) Get(name string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, name); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Append has no server side support in S3, every append is put as its own object under name.d/,
+// so concurrent runs never overwrite each other, ReadAppended join them
+func (s *S3) Append(name string, data []byte) error {
+	return s.Put(partsDir(name)+partName(), data)
+}
+
+// List return the object names start with prefix, sorted
+func (s *S3) List(prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.Prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.request(http.MethodGet, "/"+s.Bucket, query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = s.check(resp, prefix)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *S3) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = s.check(resp, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *S3) Size(name string) (int64, error) {
+	resp, err := s.do(http.MethodHead, name, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := s.check(resp, name); err != nil {
+		return 0, err
+	}
+	return resp.ContentLength, nil
+}
+
+// Lock put name.lock only if it not exist, a bucket without conditional writes ignore If-None-Match
+// and the lock always succeed
+func (s *S3) Lock(name string) (func(), error) {
+	key := name + ".lock"
+	err := waitLock(s.Location(key), func() (bool, error) {
+		resp, err := s.request(http.MethodPut, "/"+s.Bucket+"/"+s.Prefix+key, nil,
+			http.Header{"If-None-Match": []string{"*"}}, []byte(fmt.Sprintf("%d\n", os.Getpid())))
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+			s.removeStaleLock(key)
+			return false, nil
+		}
+		return true, s.check(resp, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = s.Delete(key) }, nil
+}
+
+func (s *S3) removeStaleLock(key string) {
+	resp, err := s.do(http.MethodHead, key, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err == nil && time.Since(modified) > lockStale {
+		log.Printf("Remove stale lock %s", s.Location(key))
+		_ = s.Delete(key)
+	}
+}
+
+func (s *S3) Location(name string) string {
+	return fmt.Sprintf("s3://%s/%s%s", s.Bucket, s.Prefix, name)
+}
+
+func (s *S3) check(resp *http.Response, name string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, s.Location(name), strings.TrimSpace(string(body)))
+}
+
+// do send a path-style request of the object name, signed with AWS signature v4
+func (s *S3) do(method, name string, body []byte) (*http.Response, error) {
+	return s.request(method, "/"+s.Bucket+"/"+s.Prefix+name, nil, nil, body)
+}
+
+func (s *S3) request(method, objectPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body, time.Now().UTC())
+	return s.HTTP.Do(req)
+}
+
+// sign add the AWS signature v4 Authorization header, host, range, content-type, content-md5
+// and every x-amz-* header are signed
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" || k == "content-md5" {
+			values[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	headers := make([]string, 0, len(values))
+	for h := range values {
+		headers = append(headers, h)
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + values[h] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery sort the query by key and uri-encode keys and values, '/' included
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escapePath uri-encode every byte except the unreserved characters and '/', as signature v4 expect
+func escapePath(p string) string {
+	return uriEncode(p, false)
+}
+
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
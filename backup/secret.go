@@ -0,0 +1,314 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"k8sctl/config"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	// base64 of version | ephemeral public key | nonce | AES-GCM sealed json of data, stringData and the last-applied annotation
+	EncryptedAnnotation = "k8sctl.io/encrypted-data"
+	// the secret values are left out of the backup, only the keys are kept
+	RedactedAnnotation = "k8sctl.io/redacted"
+
+	publicKeyPrefix  = "k8sctl-pub-"
+	privateKeyPrefix = "K8SCTL-KEY-"
+	envelopeVersion  = 1
+	hkdfInfo         = "k8sctl secret backup v1"
+	// kubectl apply keep the whole secret in plaintext in this annotation
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// GenerateKey return a X25519 key pair, the public key go to backup.recipient of k8sctl config,
+// the private key is the identity used by restore
+func GenerateKey() (string, string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	pub := publicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	priv := privateKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+	return pub, priv, nil
+}
+
+func parsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), publicKeyPrefix))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(s), publicKeyPrefix) {
+		return nil, fmt.Errorf("backup recipient is not a k8sctl public key")
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+func parsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), privateKeyPrefix))
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(s), privateKeyPrefix) {
+		return nil, fmt.Errorf("identity is not a k8sctl private key")
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// ReadIdentity read the private key file written by backup keygen
+func ReadIdentity(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, privateKeyPrefix) {
+			return parsePrivateKey(line)
+		}
+	}
+	return nil, fmt.Errorf("not found private key in %s", path)
+}
+
+// aead derive the AES-256-GCM key of a shared secret, both public keys are bound to it
+func aead(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key, err := hkdf.Key(sha256.New, shared, salt, hkdfInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(recipient *ecdh.PublicKey, plaintext []byte) (string, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := eph.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := aead(shared, eph.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	out := []byte{envelopeVersion}
+	out = append(out, eph.PublicKey().Bytes()...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func open(identity *ecdh.PrivateKey, envelope string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1+32 || data[0] != envelopeVersion {
+		return nil, fmt.Errorf("unknown envelope version")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[1:33])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := aead(shared, ephemeral.Bytes(), identity.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := data[33:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	return gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+}
+
+func isSecret(obj map[string]any) bool {
+	return obj["kind"] == "Secret"
+}
+
+func annotate(obj map[string]any, key, value string) {
+	meta, ok := obj["metadata"].(map[string]any)
+	if !ok {
+		meta = map[string]any{}
+		obj["metadata"] = meta
+	}
+	annotations, ok := meta["annotations"].(map[string]any)
+	if !ok {
+		annotations = map[string]any{}
+		meta["annotations"] = annotations
+	}
+	annotations[key] = value
+}
+
+func annotation(obj map[string]any, key string) (string, bool) {
+	meta, _ := obj["metadata"].(map[string]any)
+	annotations, _ := meta["annotations"].(map[string]any)
+	v, ok := annotations[key].(string)
+	return v, ok
+}
+
+func removeAnnotation(obj map[string]any, key string) {
+	meta, _ := obj["metadata"].(map[string]any)
+	annotations, _ := meta["annotations"].(map[string]any)
+	delete(annotations, key)
+	if len(annotations) == 0 {
+		delete(meta, "annotations")
+	}
+}
+
+func removeManagedFields(obj map[string]any) {
+	if meta, ok := obj["metadata"].(map[string]any); ok {
+		delete(meta, "managedFields")
+	}
+}
+
+// ProtectSecrets make the Secrets in objs safe to write, the payload is encrypted to backup.recipient of k8sctl config,
+// or redacted when redact is set or no recipient is configured. Secrets are never written in plaintext
+func ProtectSecrets(objs []map[string]any, redact bool) error {
+	var recipient *ecdh.PublicKey
+	if !redact {
+		c, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if c.Backup.Recipient != "" {
+			if recipient, err = parsePublicKey(c.Backup.Recipient); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, obj := range objs {
+		if !isSecret(obj) {
+			continue
+		}
+		if recipient == nil {
+			if !redact {
+				log.Printf("WARN: backup.recipient is not set in %s, secret %s is redacted", config.Path(), objectRef(obj).Name)
+			}
+			redactSecret(obj)
+			continue
+		}
+		if err := encryptSecret(obj, recipient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactSecret keep the type and the keys of a secret, values are emptied
+func redactSecret(obj map[string]any) {
+	removeAnnotation(obj, lastAppliedAnnotation)
+	removeManagedFields(obj)
+	for _, field := range []string{"data", "stringData"} {
+		if m, ok := obj[field].(map[string]any); ok {
+			for k := range m {
+				m[k] = ""
+			}
+		}
+	}
+	annotate(obj, RedactedAnnotation, "true")
+}
+
+// encryptSecret seal data, stringData and the last-applied annotation of a secret into EncryptedAnnotation
+func encryptSecret(obj map[string]any, recipient *ecdh.PublicKey) error {
+	payload := map[string]any{}
+	for _, field := range []string{"data", "stringData"} {
+		if v, ok := obj[field]; ok {
+			payload[field] = v
+			delete(obj, field)
+		}
+	}
+	if v, ok := annotation(obj, lastAppliedAnnotation); ok {
+		payload[lastAppliedAnnotation] = v
+		removeAnnotation(obj, lastAppliedAnnotation)
+	}
+	removeManagedFields(obj)
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	envelope, err := seal(recipient, plaintext)
+	if err != nil {
+		return err
+	}
+	annotate(obj, EncryptedAnnotation, envelope)
+	return nil
+}
+
+// DecryptSecrets put back the payload of encrypted Secrets in objs, identity is the private key file,
+// an error is returned when objs has encrypted Secrets and identity is empty
+func DecryptSecrets(objs []map[string]any, identity string) error {
+	var key *ecdh.PrivateKey
+	for _, obj := range objs {
+		envelope, ok := annotation(obj, EncryptedAnnotation)
+		if !isSecret(obj) || !ok {
+			continue
+		}
+		if key == nil {
+			if identity == "" {
+				return fmt.Errorf("backup has encrypted secrets, set --identity to the private key file")
+			}
+			var err error
+			if key, err = ReadIdentity(identity); err != nil {
+				return err
+			}
+		}
+
+		plaintext, err := open(key, envelope)
+		if err != nil {
+			return fmt.Errorf("decrypt secret %s err: %v", objectRef(obj).Name, err)
+		}
+		payload := map[string]any{}
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return err
+		}
+		for k, v := range payload {
+			if k == lastAppliedAnnotation {
+				if v, ok := v.(string); ok {
+					annotate(obj, k, v)
+				}
+				continue
+			}
+			obj[k] = v
+		}
+		removeAnnotation(obj, EncryptedAnnotation)
+	}
+	return nil
+}
+
+// Redacted report whether obj is a Secret written without its values
+func Redacted(obj map[string]any) bool {
+	v, ok := annotation(obj, RedactedAnnotation)
+	return isSecret(obj) && ok && v == "true"
+}
+
+// SkipRedacted leave out redacted Secrets, restoring them would overwrite the live values with empty ones
+func SkipRedacted(objs []map[string]any) []map[string]any {
+	var kept []map[string]any
+	for _, obj := range objs {
+		if Redacted(obj) {
+			ref := objectRef(obj)
+			log.Printf("Secret %s/%s is redacted in the backup, skip it", ref.Namespace, ref.Name)
+			continue
+		}
+		kept = append(kept, obj)
+	}
+	return kept
+}
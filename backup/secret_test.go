@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plaintext is in data, stringData and the last-applied annotation
+const plaintext = "cGFzc3dvcmQ="
+
+func testSecret() map[string]any {
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      "db",
+			"namespace": "dev",
+			"annotations": map[string]any{
+				"team":                "infra",
+				lastAppliedAnnotation: `{"apiVersion":"v1","data":{"password":"` + plaintext + `"},"kind":"Secret"}`,
+			},
+			"managedFields": []any{map[string]any{"manager": "kubectl", "fieldsV1": map[string]any{"f:data": map[string]any{"f:password": map[string]any{}}}}},
+		},
+		"type":       "Opaque",
+		"data":       map[string]any{"password": plaintext},
+		"stringData": map[string]any{"user": "admin"},
+	}
+}
+
+func testIdentity(t *testing.T) (string, string) {
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "identity")
+	if err := os.WriteFile(path, []byte(priv+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pub, path
+}
+
+func TestSealOpen(t *testing.T) {
+	pub, path := testIdentity(t)
+	recipient, err := parsePublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ReadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other := testIdentity(t)
+	otherIdentity, err := ReadIdentity(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "empty", plaintext: []byte{}},
+		{name: "json", plaintext: []byte(`{"data":{"password":"` + plaintext + `"}}`)},
+		{name: "binary", plaintext: bytes.Repeat([]byte{0, 1, 2, 255}, 1024)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := seal(recipient, tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := open(identity, envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Errorf("open got %q, want %q", got, tt.plaintext)
+			}
+			if _, err := open(otherIdentity, envelope); err == nil {
+				t.Error("open with another identity should fail")
+			}
+			raw, _ := base64.StdEncoding.DecodeString(envelope)
+			raw[len(raw)-1] ^= 1
+			if _, err := open(identity, base64.StdEncoding.EncodeToString(raw)); err == nil {
+				t.Error("open a tampered envelope should fail")
+			}
+		})
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	pub, path := testIdentity(t)
+	recipient, err := parsePublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := testSecret()
+	want := testSecret()
+	delete(want["metadata"].(map[string]any), "managedFields")
+
+	if err := encryptSecret(obj, recipient); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecryptSecrets([]map[string]any{obj}, path); err != nil {
+		t.Fatal(err)
+	}
+	// compare as json, the payload come back from json
+	gotJSON, _ := json.Marshal(obj)
+	wantJSON, _ := json.Marshal(want)
+	var got, exp any
+	_ = json.Unmarshal(gotJSON, &got)
+	_ = json.Unmarshal(wantJSON, &exp)
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("decrypted secret\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestNoPlaintext(t *testing.T) {
+	pub, _ := testIdentity(t)
+	recipient, err := parsePublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		protect func(obj map[string]any) error
+	}{
+		{name: "encrypt", protect: func(obj map[string]any) error { return encryptSecret(obj, recipient) }},
+		{name: "redact", protect: func(obj map[string]any) error { redactSecret(obj); return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testSecret()
+			if err := tt.protect(obj); err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, leak := range []string{plaintext, "admin", "last-applied-configuration", "managedFields"} {
+				if strings.Contains(string(data), leak) {
+					t.Errorf("%q left in %s", leak, data)
+				}
+			}
+			if v, _ := annotation(obj, "team"); v != "infra" {
+				t.Errorf("other annotations should be kept, got %s", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"k8sctl/manifest"
+	"k8sctl/utils"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const snapshotManifest = "manifest.json"
+
+// SnapshotManifest is manifest.json in the snapshot archive
+type SnapshotManifest struct {
+	Namespace string         `json:"namespace"`
+	Time      time.Time      `json:"time"`
+	User      string         `json:"user"`
+	Context   string         `json:"context"`
+	Cluster   string         `json:"cluster,omitempty"`
+	Objects   []SnapshotFile `json:"objects"`
+}
+
+type SnapshotFile struct {
+	ObjectRef
+	File string `json:"file"`
+}
+
+// ErrEmptyNamespace is returned by SnapshotNamespace when ns has nothing to save
+var ErrEmptyNamespace = errors.New("not found any object in namespace")
+
+// SnapshotNamespace save every deployment, service, cronjob, statefulset, configmap, ingress, hpa, pdb and secret
+// of ns into one tar.gz, each object is a yaml file <kind>/<name>.yaml. secrets are encrypted or redacted, see ProtectSecrets
+func SnapshotNamespace(cs *kubernetes.Clientset, ns string, redactSecrets bool) (*Entry, error) {
+	objs, err := manifest.FetchApp(cs, ns, "")
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := manifest.FetchSecrets(cs, ns)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, secrets...)
+	if err := ProtectSecrets(objs, redactSecrets); err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("%w = %s", ErrEmptyNamespace, ns)
+	}
+
+	data, refs, err := writeSnapshot(ns, objs)
+	if err != nil {
+		return nil, err
+	}
+	return SaveFile("backup namespace", ns, "snapshot", ".tar.gz", data, refs)
+}
+
+func writeSnapshot(ns string, objs []map[string]any) ([]byte, []ObjectRef, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	_, server := utils.CurrentCluster()
+	m := SnapshotManifest{
+		Namespace: ns,
+		Time:      now,
+		User:      utils.CurrentUser(),
+		Context:   utils.CurrentContext(),
+		Cluster:   server,
+	}
+	var refs []ObjectRef
+	for _, obj := range objs {
+		if meta, ok := obj["metadata"].(map[string]any); ok {
+			delete(meta, "managedFields")
+		}
+		ref := objectRef(obj)
+		file := path.Join(strings.ToLower(ref.Kind), ref.Name+".yaml")
+
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := add(file, data); err != nil {
+			return nil, nil, err
+		}
+		m.Objects = append(m.Objects, SnapshotFile{ObjectRef: ref, File: file})
+		refs = append(refs, ref)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := add(snapshotManifest, data); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), refs, nil
+}
+
+func objectRef(obj map[string]any) ObjectRef {
+	ref := ObjectRef{Kind: fmt.Sprint(obj["kind"])}
+	if meta, ok := obj["metadata"].(map[string]any); ok {
+		ref.Name, _ = meta["name"].(string)
+		ref.Namespace, _ = meta["namespace"].(string)
+	}
+	return ref
+}
+
+// IsSnapshot report whether data is a gzip archive
+func IsSnapshot(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// ReadSnapshot return the manifest and every object of a snapshot archive
+func ReadSnapshot(data []byte) (*SnapshotManifest, []map[string]any, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gr.Close()
+
+	m := &SnapshotManifest{}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Name == snapshotManifest {
+			if err := json.Unmarshal(content, m); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		files[h.Name] = content
+	}
+
+	var objs []map[string]any
+	for _, f := range m.Objects {
+		content, ok := files[f.File]
+		if !ok {
+			return nil, nil, fmt.Errorf("snapshot file %s of %s/%s not found in archive", f.File, f.Kind, f.Name)
+		}
+		var obj map[string]any
+		if err := yaml.Unmarshal(content, &obj); err != nil {
+			return nil, nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return m, objs, nil
+}
+
+const (
+	// yaml backups start with these comments
+	clusterHeader = "# k8sctl-cluster: "
+	contextHeader = "# k8sctl-context: "
+)
+
+// Load read the objects of a backup and the api server it was taken from, arg is a backup file or a backup id,
+// both yaml backups and namespace snapshots are supported. the cluster is empty for backups without it
+func Load(arg string) (string, []map[string]any, error) {
+	data, err := os.ReadFile(arg)
+	if os.IsNotExist(err) {
+		_, data, err = Show(arg)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if IsSnapshot(data) {
+		m, objs, err := ReadSnapshot(data)
+		if err != nil {
+			return "", nil, err
+		}
+		return m.Cluster, objs, nil
+	}
+
+	var cluster string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasPrefix(line, clusterHeader) {
+			cluster = strings.TrimSpace(strings.TrimPrefix(line, clusterHeader))
+		}
+	}
+	objs, err := manifest.Parse(data)
+	return cluster, objs, err
+}
+
+// CheckCluster refuse a backup taken from another cluster than the current one, or from an unknown cluster, unless force
+func CheckCluster(cluster string, force bool) error {
+	_, current := utils.CurrentCluster()
+	if cluster == "" {
+		if force {
+			log.Printf("WARN: not known which cluster the backup is taken from, continue by --force")
+			return nil
+		}
+		return fmt.Errorf("backup has no cluster header, it may be taken by an older k8sctl from any cluster, use --force to go on")
+	}
+	if current == "" {
+		log.Printf("WARN: not known the current cluster, backup is taken from cluster %s, continue ...", cluster)
+		return nil
+	}
+	if cluster == current {
+		return nil
+	}
+	if force {
+		log.Printf("WARN: backup is taken from cluster %s, current cluster is %s, continue by --force", cluster, current)
+		return nil
+	}
+	return fmt.Errorf("backup is taken from cluster %s, current cluster is %s, use --force to go on", cluster, current)
+}
@@ -0,0 +1,334 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"k8sctl/config"
+	"k8sctl/utils"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// Storage keep backup files by name, Get and Size return an error wrapping fs.ErrNotExist for a missing file
+type Storage interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	// Append add data to the end of name, create it when missing, concurrent appends are never lost.
+	// read an appended name with ReadAppended
+	Append(name string, data []byte) error
+	// List return the names start with prefix, sorted
+	List(prefix string) ([]string, error)
+	Delete(name string) error
+	Size(name string) (int64, error)
+	// Location is the full path or url of name, for logs
+	Location(name string) string
+	// Lock wait for the exclusive lock of name across runs, call the returned func to release it
+	Lock(name string) (func(), error)
+}
+
+const (
+	// how long Lock wait for another run
+	lockTimeout = 60 * time.Second
+	// a lock older than it is left by a killed run
+	lockStale = 5 * time.Minute
+)
+
+// waitLock call try until it take the lock
+func waitLock(location string, try func() (bool, error)) error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := try()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wait lock %s timeout, remove it if no other k8sctl is running", location)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Open return the storage of backup.storage in k8sctl config, default the local backup directory.
+// backups of each cluster and context are kept apart under <cluster>/<context>
+func Open() (Storage, error) {
+	return openAt(utils.ClusterDir())
+}
+
+// OpenLegacy return the flat storage used before backups were kept per cluster and context,
+// nil when it has no backup index. it is only read, new backups never go there
+func OpenLegacy() (Storage, error) {
+	s, err := openAt("")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Size(indexFile); errors.Is(err, fs.ErrNotExist) {
+		if _, err := s.Size(AuditFile); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// openAt return the storage of sub under the configured directory or prefix,
+// --state-dir and $K8SCTL_HOME take precedence over backup.storage.dir
+func openAt(sub string) (Storage, error) {
+	c, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := c.Backup.Storage
+	switch sc.Type {
+	case "", StorageLocal:
+		dir := utils.StateDir()
+		if sc.Dir != "" && !utils.StateDirSet() {
+			dir = sc.Dir
+		}
+		dir = filepath.Join(dir, sub)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &Local{Dir: dir}, nil
+	case StorageS3:
+		if sub != "" {
+			sc.S3.Prefix = path.Join(sc.S3.Prefix, sub) + "/"
+		}
+		return NewS3(sc.S3)
+	default:
+		return nil, fmt.Errorf("backup.storage.type = %s not match local|s3", sc.Type)
+	}
+}
+
+// partsDir keep the appends of name on a storage without append, one object each
+func partsDir(name string) string {
+	return name + ".d/"
+}
+
+// partName sort by time, the pid and random part keep runs at the same moment apart
+func partName() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", time.Now().UTC().Format("20060102T150405.000000000Z"), os.Getpid(), hex.EncodeToString(b))
+}
+
+// ReadAppended return name followed by every append kept apart under name.d/, oldest first
+func ReadAppended(s Storage, name string) ([]byte, error) {
+	data, _, err := readAppended(s, name)
+	return data, err
+}
+
+// readAppended is ReadAppended with the parts read, a rewrite of name delete them after
+func readAppended(s Storage, name string) ([]byte, []string, error) {
+	data, err := s.Get(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	found := err == nil
+
+	parts, err := s.List(partsDir(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	var read []string
+	for _, p := range parts {
+		part, err := s.Get(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			// compacted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, part...)
+		read = append(read, p)
+		found = true
+	}
+	if !found {
+		return nil, nil, fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+	}
+	return data, read, nil
+}
+
+// LogWriter return a writer append to name of the backup storage, a local file is written at once,
+// other storages get the log of the whole run by FlushLogs, so a run cost one upload
+func LogWriter(name string) (io.Writer, error) {
+	s, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	w := &logWriter{storage: s, name: name}
+	if _, ok := s.(*Local); !ok {
+		w.buf = new(bytes.Buffer)
+		logsMu.Lock()
+		logs = append(logs, w)
+		logsMu.Unlock()
+	}
+	return w, nil
+}
+
+var (
+	logsMu sync.Mutex
+	// buffered log writers not flushed yet
+	logs []*logWriter
+)
+
+// FlushLogs upload the buffered logs of LogWriter, called once before k8sctl exit
+func FlushLogs() error {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	var errs []error
+	for _, w := range logs {
+		if err := w.flush(); err != nil {
+			errs = append(errs, fmt.Errorf("flush %s: %w", w.storage.Location(w.name), err))
+		}
+	}
+	logs = nil
+	return errors.Join(errs...)
+}
+
+type logWriter struct {
+	storage Storage
+	name    string
+	mu      sync.Mutex
+	// nil for a local file
+	buf *bytes.Buffer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	if w.buf != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.buf.Write(p)
+	}
+	if err := w.storage.Append(w.name, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	if err := w.storage.Append(w.name, w.buf.Bytes()); err != nil {
+		return err
+	}
+	w.buf.Reset()
+	return nil
+}
+
+// Local is a local directory
+type Local struct {
+	Dir string
+}
+
+func (l *Local) Put(name string, data []byte) error {
+	tmp := filepath.Join(l.Dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.Dir, name))
+}
+
+func (l *Local) Get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.Dir, name))
+}
+
+func (l *Local) Append(name string, data []byte) error {
+	f, err := os.OpenFile(filepath.Join(l.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+func (l *Local) List(prefix string) ([]string, error) {
+	pattern := filepath.Join(l.Dir, filepath.FromSlash(prefix))
+	if strings.HasSuffix(prefix, "/") {
+		pattern += string(filepath.Separator)
+	}
+	matches, err := filepath.Glob(pattern + "*")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err != nil || fi.IsDir() || strings.HasSuffix(m, ".tmp") {
+			continue
+		}
+		rel, err := filepath.Rel(l.Dir, m)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (l *Local) Delete(name string) error {
+	err := os.Remove(filepath.Join(l.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) Size(name string) (int64, error) {
+	fi, err := os.Stat(filepath.Join(l.Dir, name))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Lock create name.lock exclusively
+func (l *Local) Lock(name string) (func(), error) {
+	p := filepath.Join(l.Dir, name+".lock")
+	err := waitLock(p, func() (bool, error) {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			if fi, err := os.Stat(p); err == nil && time.Since(fi.ModTime()) > lockStale {
+				log.Printf("Remove stale lock %s", p)
+				_ = os.Remove(p)
+			}
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+		return true, f.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = os.Remove(p) }, nil
+}
+
+func (l *Local) Location(name string) string {
+	return filepath.Join(l.Dir, name)
+}
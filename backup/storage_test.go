@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStorage keep appends as parts like S3
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (m *memStorage) Put(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memStorage) Get(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return data, nil
+}
+
+func (m *memStorage) Append(name string, data []byte) error {
+	return m.Put(partsDir(name)+partName(), data)
+}
+
+func (m *memStorage) List(prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memStorage) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memStorage) Size(name string) (int64, error) {
+	data, err := m.Get(name)
+	return int64(len(data)), err
+}
+
+func (m *memStorage) Lock(name string) (func(), error) {
+	return func() {}, nil
+}
+
+func (m *memStorage) Location(name string) string {
+	return "mem://" + name
+}
+
+func TestReadAppended(t *testing.T) {
+	for _, s := range []Storage{newMemStorage(), &Local{Dir: t.TempDir()}} {
+		if _, err := ReadAppended(s, "ops.log"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%T: missing log err = %v, want fs.ErrNotExist", s, err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := s.Append("ops.log", []byte(fmt.Sprintf("line %02d\n", i))); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		data, err := ReadAppended(s, "ops.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 20 {
+			t.Errorf("%T: got %d lines, want 20, concurrent appends lost", s, len(lines))
+		}
+	}
+}
+
+func TestWriteIndexCompactParts(t *testing.T) {
+	s := newMemStorage()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := appendIndex(s, &Entry{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, parts, err := readIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || len(parts) != 3 {
+		t.Fatalf("got %d entries in %d parts, want 3 in 3", len(entries), len(parts))
+	}
+
+	// appended while the index is rewritten
+	if err := appendIndex(s, &Entry{ID: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndex(s, entries[1:], parts); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err = readIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	if got := strings.Join(ids, ","); got != "b,c,d" {
+		t.Errorf("index = %s, want b,c,d", got)
+	}
+}
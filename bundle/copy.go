@@ -0,0 +1,242 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/cronjob"
+	"k8sctl/deployment"
+	"k8sctl/preview"
+	"k8sctl/statefulset"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Bundle is every object of one app, selected by label, e.g. app=foo
+type Bundle struct {
+	Client       *kubernetes.Clientset
+	Selector     string
+	Namespace    string
+	NewNamespace string
+	ImageTag     string
+	Replicas     int32
+	Owner        string
+	TTL          time.Duration
+}
+
+// Result of one copied object
+type Result struct {
+	Kind   string
+	Name   string
+	Status string
+	Err    error
+}
+
+// Copy copy the bundle in dependency order: configmaps, services, then workloads,
+// and wait for all workloads ready
+func (b *Bundle) Copy() ([]Result, error) {
+	// the objects are copied one by one without the guard of each copy command, the source would be replaced
+	if b.Namespace == b.NewNamespace {
+		return nil, fmt.Errorf("copy app %s to the same namespace = %s is not supported", b.Selector, b.Namespace)
+	}
+	var results []Result
+	add := func(kind, name string, err error) {
+		status := "copied"
+		if err != nil {
+			status = "failed"
+		}
+		results = append(results, Result{Kind: kind, Name: name, Status: status, Err: err})
+	}
+	listOpts := metav1.ListOptions{LabelSelector: b.Selector}
+
+	cms, err := b.Client.CoreV1().ConfigMaps(b.Namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	svcs, err := b.Client.CoreV1().Services(b.Namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	deploys, err := b.Client.AppsV1().Deployments(b.Namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	stss, err := b.Client.AppsV1().StatefulSets(b.Namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	cronjobs, err := b.Client.BatchV1().CronJobs(b.Namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(cms.Items) + len(svcs.Items) + len(deploys.Items) + len(stss.Items) + len(cronjobs.Items)
+	if total == 0 {
+		return nil, fmt.Errorf("在命名空间= %s 没有发现标签为 %s 的资源", b.Namespace, b.Selector)
+	}
+	log.Printf("Found %d configmap, %d service, %d deployment, %d statefulset, %d cronjob with %s in namespace = %s",
+		len(cms.Items), len(svcs.Items), len(deploys.Items), len(stss.Items), len(cronjobs.Items), b.Selector, b.Namespace)
+
+	// config
+	for _, cm := range cms.Items {
+		add("ConfigMap", cm.Name, b.copyConfigMap(&cm))
+	}
+
+	// services
+	for _, svc := range svcs.Items {
+		changes, err := b.deploySpec(svc.Name).CopySvc()
+		for _, c := range changes {
+			log.Printf("Copy service = %s, namespace = %s changed %s", svc.Name, b.NewNamespace, c)
+		}
+		add("Service", svc.Name, err)
+	}
+
+	// workloads
+	var waitDeploys, waitStss []string
+	for _, deploy := range deploys.Items {
+		err := b.deploySpec(deploy.Name).CopyDeploy()
+		add("Deployment", deploy.Name, err)
+		if err == nil {
+			waitDeploys = append(waitDeploys, deploy.Name)
+		}
+	}
+	for _, sts := range stss.Items {
+		s := &statefulset.StatefulSetSpec{
+			Client:       b.Client,
+			Name:         sts.Name,
+			Namespace:    b.Namespace,
+			NewNamespace: b.NewNamespace,
+			ImageTag:     b.ImageTag,
+			Replicas:     b.Replicas,
+			Owner:        b.Owner,
+			TTL:          b.TTL,
+			CopyName:     b.copyName(),
+		}
+		err := s.CopyStatefulSet()
+		add("StatefulSet", sts.Name, err)
+		if err == nil {
+			waitStss = append(waitStss, sts.Name)
+		}
+	}
+	for _, cj := range cronjobs.Items {
+		c := &cronjob.CronJob{
+			Client:       b.Client,
+			Name:         cj.Name,
+			Namespace:    b.Namespace,
+			NewNamespace: b.NewNamespace,
+			ImageTag:     b.ImageTag,
+			Owner:        b.Owner,
+			TTL:          b.TTL,
+			CopyName:     b.copyName(),
+		}
+		add("CronJob", cj.Name, c.CreateNew())
+	}
+
+	// wait for workloads
+	for _, name := range waitDeploys {
+		b.setReady(results, "Deployment", name, deployment.WaitDeploymentUpdate(b.Client, b.NewNamespace, name, 180))
+	}
+	for _, name := range waitStss {
+		b.setReady(results, "StatefulSet", name, statefulset.WaitStatefulSetUpdate(b.Client, b.NewNamespace, name, 300))
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return results, fmt.Errorf("copy %s failed, please check the result", b.Selector)
+		}
+	}
+	return results, nil
+}
+
+func (b *Bundle) setReady(results []Result, kind, name string, err error) {
+	for i := range results {
+		if results[i].Kind != kind || results[i].Name != name {
+			continue
+		}
+		if err != nil {
+			results[i].Status = "not ready"
+			results[i].Err = err
+		} else {
+			results[i].Status = "ready"
+		}
+	}
+}
+
+// copyName is the k8sctl.io/copy annotation of every object in the bundle
+func (b *Bundle) copyName() string {
+	sel, err := metav1.ParseToLabelSelector(b.Selector)
+	if err == nil {
+		if app, ok := sel.MatchLabels["app"]; ok {
+			return app
+		}
+	}
+	return b.Selector
+}
+
+func (b *Bundle) deploySpec(name string) *deployment.DeploySpec {
+	return &deployment.DeploySpec{
+		Client:       b.Client,
+		Name:         name,
+		Namespace:    b.Namespace,
+		NewNamespace: b.NewNamespace,
+		ImageTag:     b.ImageTag,
+		Replicas:     b.Replicas,
+		Owner:        b.Owner,
+		TTL:          b.TTL,
+		CopyName:     b.copyName(),
+	}
+}
+
+func (b *Bundle) copyConfigMap(ori *corev1.ConfigMap) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ori.Name,
+			Namespace:   b.NewNamespace,
+			Labels:      ori.Labels,
+			Annotations: make(map[string]string, len(ori.Annotations)),
+		},
+		Data:       ori.Data,
+		BinaryData: ori.BinaryData,
+	}
+	for k, v := range ori.Annotations {
+		cm.Annotations[k] = v
+	}
+	delete(cm.Annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	m := &preview.Mark{
+		Owner:  b.Owner,
+		Source: b.Namespace + "/" + ori.Name,
+		Copy:   b.copyName(),
+		TTL:    b.TTL,
+	}
+	m.Annotate(&cm.ObjectMeta)
+
+	dst, err := b.Client.CoreV1().ConfigMaps(b.NewNamespace).Get(context.TODO(), cm.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if err := preview.RefuseNotCopy("configmap", dst); err != nil {
+			return err
+		}
+		log.Printf("ConfigMap = %s, namespace = %s has found. Updating it ...", cm.Name, b.NewNamespace)
+		if _, err := backup.BeforeMutation("copy app", dst); err != nil {
+			return err
+		}
+		// update is rejected without the resourceVersion of the live object
+		cm.ResourceVersion = dst.ResourceVersion
+		_, err = b.Client.CoreV1().ConfigMaps(b.NewNamespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	} else {
+		_, err = b.Client.CoreV1().ConfigMaps(b.NewNamespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		log.Printf("Copy configmap = %s, namespace = %s err: %v", cm.Name, b.NewNamespace, err)
+		return err
+	}
+	log.Printf("Copy configmap = %s, namespace = %s complete.", cm.Name, b.NewNamespace)
+	return nil
+}
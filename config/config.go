@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the k8sctl config file, default ~/.kube/k8sctl.yaml, override by env K8SCTL_CONFIG
+type Config struct {
+	NamespaceTemplate NamespaceTemplate `json:"namespaceTemplate,omitempty"`
+	// annotation prefix -> keep|drop, for copy --service-type loadbalancer|keep,
+	// cloud lb annotations not in it are dropped
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+	Backup             Backup            `json:"backup,omitempty"`
+}
+
+type Backup struct {
+	Retention Retention `json:"retention,omitempty"`
+	Storage   Storage   `json:"storage,omitempty"`
+	// public key from `k8sctl backup keygen`, Secret payloads are encrypted to it,
+	// Secrets are redacted when it is empty
+	Recipient string `json:"recipient,omitempty"`
+}
+
+// Storage is where backups, index.jsonl and ops.log are written
+type Storage struct {
+	// local or s3, default local
+	Type string `json:"type,omitempty"`
+	// local directory, default ~/.kube/k8sctl-backups, --state-dir and $K8SCTL_HOME take precedence
+	Dir string `json:"dir,omitempty"`
+	S3  S3     `json:"s3,omitempty"`
+}
+
+// S3 is an S3-compatible bucket, e.g. AWS S3 or MinIO, objects are addressed path-style
+type S3 struct {
+	// e.g. https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000
+	Endpoint string `json:"endpoint"`
+	// default us-east-1
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// key prefix, e.g. k8sctl-backups/
+	Prefix string `json:"prefix,omitempty"`
+	// default env AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+}
+
+// Retention is enforced after every backup, zero means no limit,
+// a backup is kept when it match keepLast or keepDays, the latest backup of an object is always kept
+type Retention struct {
+	// keep the last N backups per object
+	KeepLast int `json:"keepLast,omitempty"`
+	// keep every backup newer than D days
+	KeepDays int `json:"keepDays,omitempty"`
+	// max total size of the backup files, e.g. 500Mi
+	MaxTotalSize string `json:"maxTotalSize,omitempty"`
+}
+
+// NamespaceTemplate is used by copy --create-namespace
+type NamespaceTemplate struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// created as ResourceQuota k8sctl-quota
+	ResourceQuota *corev1.ResourceQuotaSpec `json:"resourceQuota,omitempty"`
+	// created as LimitRange k8sctl-limits
+	LimitRange      *corev1.LimitRangeSpec `json:"limitRange,omitempty"`
+	NetworkPolicies []NetworkPolicy        `json:"networkPolicies,omitempty"`
+	// secrets copied from the source namespace and added to the default serviceaccount
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+type NetworkPolicy struct {
+	Name string                         `json:"name"`
+	Spec networkingv1.NetworkPolicySpec `json:"spec"`
+}
+
+func Path() string {
+	if p := os.Getenv("K8SCTL_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(homedir.HomeDir(), ".kube", "k8sctl.yaml")
+}
+
+// Load read the config file, a missing file is an empty config
+func Load() (*Config, error) {
+	c := &Config{}
+	data, err := os.ReadFile(Path())
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package cronjob
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/preview"
+	"k8sctl/utils"
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreateNew copy cronjob from Namespace to NewNamespace, an existing one in NewNamespace will be recreated.
+// batch/v1 is used here, since batch/v1beta1 cronjob was removed from k8s 1.25
+func (c *CronJob) CreateNew() error {
+	if c.Namespace == c.NewNamespace {
+		return fmt.Errorf("copy cronjob = %s to the same namespace = %s is not supported", c.Name, c.Namespace)
+	}
+	log.Println("Copy CronJob ...")
+	src, err := c.Client.BatchV1().CronJobs(c.Namespace).Get(context.TODO(), c.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("在命名空间= %s 没有发现 cronjob= %s, 请先部署到命名空间 %s,再重试", c.Namespace, c.Name, c.Namespace)
+	}
+	log.Printf("CronJob = %s, namespace = %s has found. Continue ...", c.Name, c.Namespace)
+
+	dst, err := c.Client.BatchV1().CronJobs(c.NewNamespace).Get(context.TODO(), c.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if err := preview.RefuseNotCopy("cronjob", dst); err != nil {
+			return err
+		}
+		log.Printf("CronJob = %s, namespace = %s has found. Recreating it ...", c.Name, c.NewNamespace)
+		if _, err := backup.BeforeMutation("copy cronjob", dst); err != nil {
+			return err
+		}
+		if ok := c.DeleteNew(); !ok {
+			log.Println("Delete cronjob failed")
+		}
+	}
+
+	newCronjob := c.newCronjob(src)
+	if _, err := c.Client.BatchV1().CronJobs(c.NewNamespace).Create(context.TODO(), newCronjob, metav1.CreateOptions{}); err != nil {
+		log.Printf("Create cronjob = %s, namespace = %s err %s\n", c.Name, c.NewNamespace, err)
+		return err
+	}
+	log.Printf("Create cronjob = %s, namespace = %s complete, suspend = %t.\n", c.Name, c.NewNamespace, c.Suspend)
+
+	return nil
+}
+
+func (c *CronJob) newCronjob(src *batchv1.CronJob) *batchv1.CronJob {
+	cronjob := src.DeepCopy()
+	cronjob.Namespace = c.NewNamespace
+	cronjob.ResourceVersion = ""
+	cronjob.UID = ""
+	cronjob.ManagedFields = nil
+	cronjob.Status = batchv1.CronJobStatus{}
+
+	if c.Suspend {
+		suspend := true
+		cronjob.Spec.Suspend = &suspend
+	}
+
+	containers := cronjob.Spec.JobTemplate.Spec.Template.Spec.Containers
+	if c.ImageTag != "" && len(containers) > 0 {
+		containers[0].Image = utils.ReplaceImageTag(containers[0].Image, c.ImageTag)
+	}
+
+	copyName := c.CopyName
+	if copyName == "" {
+		copyName = c.Name
+	}
+	m := &preview.Mark{
+		Owner:  c.Owner,
+		Source: c.Namespace + "/" + c.Name,
+		Copy:   copyName,
+		TTL:    c.TTL,
+	}
+	m.Annotate(&cronjob.ObjectMeta)
+	return cronjob
+}
+
+// DeleteNew delete cronjob in NewNamespace after a success dry-run
+func (c *CronJob) DeleteNew() bool {
+	err := c.Client.BatchV1().CronJobs(c.NewNamespace).Delete(context.TODO(), c.Name, metav1.DeleteOptions{
+		DryRun: []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		log.Printf("Dryrun delete cronjob = %s, namespace = %s err: %s\n", c.Name, c.NewNamespace, err)
+		return false
+	}
+	log.Printf("Dryrun delete cronjob = %s, namespace = %s successfully.\n", c.Name, c.NewNamespace)
+
+	propagation := metav1.DeletePropagationBackground
+	_ = c.Client.BatchV1().CronJobs(c.NewNamespace).Delete(context.TODO(), c.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	log.Printf("Delete cronjob = %s, namespace = %s successfully.\n", c.Name, c.NewNamespace)
+
+	return true
+}
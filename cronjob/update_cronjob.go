@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"k8sctl/backup"
 	"k8sctl/utils"
 	"log"
 	"os"
@@ -23,6 +24,13 @@ type CronJob struct {
 	Confirm   string
 	App       string
 	Type      string
+	// used by copy
+	NewNamespace string
+	ImageTag     string
+	Suspend      bool
+	Owner        string
+	TTL          time.Duration
+	CopyName     string
 }
 
 func NewClient(client *kubernetes.Clientset) *CronJob {
@@ -46,20 +54,17 @@ func (c *CronJob) UpdateCronjobLabels() error {
 	} else {
 		newLabels = utils.StringToMap(c.Labels)
 		if newLabels == nil {
-			log.Printf(`解析 Lables = %s 失败，请按格式"app=xx,version=xx"进行传值`, c.Labels)
-			os.Exit(1)
+			return fmt.Errorf(`解析 Lables = %s 失败，请按格式"app=xx,version=xx"进行传值`, c.Labels)
 		}
 	}
 
 	if c.Type == "api" {
-		log.Printf("Cronjob = %s in namespace = %s,不能使用标签 type=api", c.Name, c.Namespace)
-		os.Exit(1)
+		return fmt.Errorf("Cronjob = %s in namespace = %s,不能使用标签 type=api", c.Name, c.Namespace)
 	}
 
 	oriCronjob := c.getCronjob()
 	if oriCronjob == nil {
-		log.Println("获取 cronjob 失败, 请检查")
-		os.Exit(1)
+		return fmt.Errorf("获取 cronjob = %s.%s 失败, 请检查", c.Namespace, c.Name)
 	}
 
 	log.Printf("开始对 %s.%s 进行标签替换\n", c.Namespace, c.Name)
@@ -85,7 +90,7 @@ func (c *CronJob) UpdateCronjobLabels() error {
 		DeepEqual(oriCronjob.Spec.JobTemplate.ObjectMeta.Labels, newLabels) && reflect.
 		DeepEqual(oriCronjob.Spec.JobTemplate.Spec.Template.ObjectMeta.Labels, newLabels) {
 		log.Printf("要修改的 Cronjob 的标签和原标签完全一样，程序退出！！")
-		os.Exit(0)
+		return nil
 	}
 	log.Printf("是否确认执行？输入 [ y|Y ] 继续, Ctrl^C 退出（回车确认输入）: ")
 
@@ -112,6 +117,11 @@ func (c *CronJob) UpdateCronjobLabels() error {
 
 	//Force update cronjob with new labels
 
+	if _, err := backup.BeforeMutation("update cronjob labels", oriCronjob); err != nil {
+		log.Printf("备份 cronjob = %s.%s 失败: %v", c.Namespace, c.Name, err)
+		return err
+	}
+
 	log.Printf("开始修改标签 cronjob = %s, 请稍等 ...", c.Name)
 	time.Sleep(1 * time.Second)
 
@@ -119,6 +129,9 @@ func (c *CronJob) UpdateCronjobLabels() error {
 	var timeSleep = time.Second
 	for i := 1; i <= tryTimes; i++ {
 		oriCronjob := c.getCronjob()
+		if oriCronjob == nil {
+			return fmt.Errorf("获取 cronjob = %s.%s 失败, 请检查", c.Namespace, c.Name)
+		}
 		if oriCronjob.ObjectMeta.Labels != nil {
 			oriCronjob.ObjectMeta.Labels = nil
 			oriCronjob.Spec.JobTemplate.Labels = nil
@@ -134,8 +147,7 @@ func (c *CronJob) UpdateCronjobLabels() error {
 		log.Printf("第 %d 次尝试更新标签失败，将在 1s 后重试", i)
 		time.Sleep(timeSleep)
 		if i == tryTimes {
-			log.Panicf("重试 %d 后，更新 cronjob = %s.%s 失败，请联系运维人员。\n", tryTimes, c.Name, c.Namespace)
-			os.Exit(1)
+			return fmt.Errorf("重试 %d 后，更新 cronjob = %s.%s 失败，请联系运维人员: %v", tryTimes, c.Namespace, c.Name, err1)
 		}
 	}
 
@@ -0,0 +1,202 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/preview"
+	"log"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// delete the target objects if exist and create them again
+	ModeRecreate = "recreate"
+	// server-side apply, only the fields k8sctl set are updated
+	ModeApply = "apply"
+
+	FieldManager = "k8sctl"
+)
+
+// ApplyNew copy service and deployment to NewNamespace with server-side apply,
+// fields owned by other managers in the target are not taken over, they fail the apply as a conflict.
+// return whether anything in the target changed and the fields changed from the source service
+func (d *DeploySpec) ApplyNew() (bool, []ServiceChange, error) {
+	log.Println("Apply Service ...")
+	oriService := d.GetSvc(d.Name, d.Namespace)
+	if oriService == nil {
+		return false, nil, fmt.Errorf("在命名空间= %s 没有发现服务= %s, 请先部署到命名空间 %s,再重试", d.Namespace, d.Name, d.Namespace)
+	}
+
+	copied, svcChanges, err := d.copyOfSvc(oriService)
+	if err != nil {
+		return false, nil, err
+	}
+	newSvc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		Spec:     copied.Spec,
+	}
+
+	var svcRV string
+	var svcAnnotations map[string]string
+	if dst := d.GetSvc(d.targetName(), d.NewNamespace); dst != nil {
+		svcRV, svcAnnotations = dst.ResourceVersion, dst.Annotations
+		if _, err := backup.BeforeMutation("apply deployment", dst); err != nil {
+			return false, nil, err
+		}
+	}
+	newSvc.ObjectMeta = applyMeta(copied.ObjectMeta, svcAnnotations)
+	svcData, err := applyData(newSvc)
+	if err != nil {
+		return false, nil, err
+	}
+	svc, err := d.Client.CoreV1().Services(d.NewNamespace).Patch(context.TODO(), d.targetName(), types.ApplyPatchType, svcData, d.applyOptions())
+	if err != nil {
+		log.Printf("Apply service = %s, namespace = %s err: %v", d.targetName(), d.NewNamespace, err)
+		return false, nil, applyConflict("service", d.targetName(), d.NewNamespace, err)
+	}
+	svcChanged := logApplyResult("Service", d.targetName(), d.NewNamespace, svcRV, svc.ResourceVersion)
+
+	log.Println("Apply Deployment ...")
+	srcDeploy := d.getDeploy(d.Name, d.Namespace)
+	if srcDeploy == nil {
+		return false, nil, fmt.Errorf("在命名空间= %s 没有发现服务= %s, 请先部署到命名空间 %s,再重试", d.Namespace, d.Name, d.Namespace)
+	}
+
+	copiedDeploy, err := d.copyOfDeploy(srcDeploy)
+	if err != nil {
+		return false, nil, err
+	}
+	newDeploy := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		Spec:     copiedDeploy.Spec,
+	}
+
+	var deployRV string
+	var deployAnnotations map[string]string
+	if dst := d.getDeploy(d.targetName(), d.NewNamespace); dst != nil {
+		deployRV, deployAnnotations = dst.ResourceVersion, dst.Annotations
+		if _, err := backup.BeforeMutation("apply deployment", dst); err != nil {
+			return false, nil, err
+		}
+		// leave the scaling of the target to its hpa or owner
+		if d.KeepTargetReplicas {
+			newDeploy.Spec.Replicas = nil
+		}
+	}
+	newDeploy.ObjectMeta = applyMeta(copiedDeploy.ObjectMeta, deployAnnotations)
+	deployData, err := applyData(newDeploy)
+	if err != nil {
+		return false, nil, err
+	}
+	deploy, err := d.Client.AppsV1().Deployments(d.NewNamespace).Patch(context.TODO(), d.targetName(), types.ApplyPatchType, deployData, d.applyOptions())
+	if err != nil {
+		log.Printf("Apply deployment = %s, namespace = %s err: %v", d.targetName(), d.NewNamespace, err)
+		return false, nil, applyConflict("deployment", d.targetName(), d.NewNamespace, err)
+	}
+	deployChanged := logApplyResult("Deployment", d.targetName(), d.NewNamespace, deployRV, deploy.ResourceVersion)
+
+	return svcChanged || deployChanged, svcChanges, nil
+}
+
+// applyOptions never force, fields owned by other managers are reported as conflicts
+func (d *DeploySpec) applyOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{
+		FieldManager: FieldManager,
+	}
+}
+
+// runtime annotations set by controllers and kubectl, they are not part of the copy
+var runtimeAnnotationPrefixes = []string{
+	"deployment.kubernetes.io/",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// applyMeta keep only the metadata k8sctl own: name, namespace, labels and annotations of the copy,
+// the expiry of an existing target is kept, so a rerun with --ttl is not a change
+func applyMeta(copied metav1.ObjectMeta, liveAnnotations map[string]string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:        copied.Name,
+		Namespace:   copied.Namespace,
+		Labels:      copied.Labels,
+		Annotations: make(map[string]string, len(copied.Annotations)),
+	}
+	for k, v := range copied.Annotations {
+		if !hasAnyPrefix(k, runtimeAnnotationPrefixes) {
+			meta.Annotations[k] = v
+		}
+	}
+	if _, ok := meta.Annotations[preview.AnnotationExpiresAt]; ok {
+		if expiresAt, ok := liveAnnotations[preview.AnnotationExpiresAt]; ok {
+			meta.Annotations[preview.AnnotationExpiresAt] = expiresAt
+		}
+	}
+	return meta
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyData marshal obj for apply without status and the empty creationTimestamp of typed objects
+func applyData(obj any) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	delete(m, "status")
+	unstructured.RemoveNestedField(m, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(m, "spec", "template", "metadata", "creationTimestamp")
+	return json.Marshal(m)
+}
+
+// applyConflict list the conflicting fields and their managers of a failed apply
+func applyConflict(kind, name, ns string, err error) error {
+	if !apierrors.IsConflict(err) {
+		return err
+	}
+	var fields []string
+	if status, ok := err.(apierrors.APIStatus); ok && status.Status().Details != nil {
+		for _, c := range status.Status().Details.Causes {
+			fields = append(fields, c.Message)
+		}
+	}
+	log.Printf("Apply %s = %s, namespace = %s conflict with other managers:", kind, name, ns)
+	for _, f := range fields {
+		log.Printf("  %s", f)
+	}
+	return fmt.Errorf("apply %s = %s, namespace = %s conflict on %d fields owned by other managers, "+
+		"remove them from the target or use --mode recreate", kind, name, ns, len(fields))
+}
+
+// logApplyResult compare resourceVersion before and after apply, the server not bump it on a no-op apply
+func logApplyResult(kind, name, ns, before, after string) bool {
+	switch {
+	case before == "":
+		log.Printf("%s = %s, namespace = %s created.", kind, name, ns)
+		return true
+	case before != after:
+		log.Printf("%s = %s, namespace = %s configured.", kind, name, ns)
+		return true
+	default:
+		log.Printf("%s = %s, namespace = %s unchanged.", kind, name, ns)
+		return false
+	}
+}
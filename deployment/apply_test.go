@@ -0,0 +1,79 @@
+package deployment
+
+import (
+	"encoding/json"
+	"k8sctl/preview"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyMeta(t *testing.T) {
+	copied := metav1.ObjectMeta{
+		Name:              "web",
+		Namespace:         "pr-1",
+		Labels:            map[string]string{"app": "web"},
+		Generation:        7,
+		CreationTimestamp: metav1.NewTime(time.Now()),
+		Annotations: map[string]string{
+			"deployment.kubernetes.io/revision":                "3",
+			"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			"team":                      "a",
+			preview.AnnotationCopy:      "web",
+			preview.AnnotationExpiresAt: "2030-01-02T00:00:00Z",
+		},
+	}
+
+	cases := []struct {
+		name string
+		live map[string]string
+		want string
+	}{
+		{"new target", nil, "2030-01-02T00:00:00Z"},
+		{"rerun keep expiry", map[string]string{preview.AnnotationExpiresAt: "2030-01-01T00:00:00Z"}, "2030-01-01T00:00:00Z"},
+	}
+	for _, c := range cases {
+		meta := applyMeta(copied, c.live)
+		if meta.Annotations[preview.AnnotationExpiresAt] != c.want {
+			t.Errorf("%s: expires-at = %s, want %s", c.name, meta.Annotations[preview.AnnotationExpiresAt], c.want)
+		}
+		if meta.Generation != 0 || !meta.CreationTimestamp.IsZero() {
+			t.Errorf("%s: runtime metadata kept: %+v", c.name, meta)
+		}
+		for _, k := range []string{"deployment.kubernetes.io/revision", "kubectl.kubernetes.io/last-applied-configuration"} {
+			if _, ok := meta.Annotations[k]; ok {
+				t.Errorf("%s: annotation %s kept", c.name, k)
+			}
+		}
+		if meta.Annotations["team"] != "a" || meta.Annotations[preview.AnnotationCopy] != "web" {
+			t.Errorf("%s: copy annotations lost: %v", c.name, meta.Annotations)
+		}
+	}
+}
+
+func TestApplyData(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+	}
+	data, err := applyData(deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["status"]; ok {
+		t.Errorf("status is applied: %s", data)
+	}
+	if _, ok := m["metadata"].(map[string]any)["creationTimestamp"]; ok {
+		t.Errorf("creationTimestamp is applied: %s", data)
+	}
+	template := m["spec"].(map[string]any)["template"].(map[string]any)["metadata"].(map[string]any)
+	if _, ok := template["creationTimestamp"]; ok {
+		t.Errorf("template creationTimestamp is applied: %s", data)
+	}
+}
@@ -3,42 +3,25 @@ package deployment
 import (
 	"context"
 	"io"
-	"k8sctl/utils"
+	"k8sctl/backup"
 	"log"
 	"os"
-	"path/filepath"
-	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/yaml"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
-func (d *DeploySpec) BackupToLocal() {
+// BackupToLocal back up the deployment, and the service of api and fe, before updating labels
+func (d *DeploySpec) BackupToLocal() error {
 
 	deploy, err := d.Client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
 
 	if err != nil {
 		log.Printf("Get deploymnet = %s.%s err: %v\n", d.Namespace, d.Name, err)
-		os.Exit(1)
+		return err
 	}
 
-	backupPath, err1 := utils.GetBackupPath()
-	if err1 != nil {
-		log.Printf("Get backup path err: %v\n", err1)
-		os.Exit(1)
-	}
-
-	deployCopy := deploy.DeepCopy()
-	deployCopy.APIVersion = "apps/v1"
-	deployCopy.Kind = "Deployment"
-	deployCopy.ManagedFields = nil
-
-	deployYaml, err := yaml.Marshal(deployCopy)
-
-	if err != nil {
-		log.Printf("Deployment = %s.%s convert to yaml err: %v", d.Namespace, d.Name, err)
-		os.Exit(1)
-	}
+	objects := []runtime.Object{deploy}
 
 	// if type = api , then add svc to yaml
 	if d.Type == "api" || d.Type == "fe" {
@@ -48,58 +31,25 @@ func (d *DeploySpec) BackupToLocal() {
 		if err != nil {
 			log.Printf("Backup service = %s.%s err: %v, please check!!, continue ...\n", d.Namespace, d.Name, err)
 		} else {
-
-			svcCopy := svc.DeepCopy()
-			svcCopy.APIVersion = "v1"
-			svcCopy.Kind = "Service"
-			svcCopy.ManagedFields = nil
-			svcBytes, err := yaml.Marshal(svcCopy)
-
-			if err != nil {
-				log.Printf("Convert service %s.%s to yaml err: %v", d.Namespace, d.Name, err)
-				os.Exit(1)
-			}
-
-			// svcYaml := fmt.Sprintf("---\n" + string(svcBytes))
-			deployYaml = append(deployYaml, "---\n"...)
-
-			// can add another slice by append(slice, anotherSlice...)
-			deployYaml = append(deployYaml, svcBytes...)
+			objects = append(objects, svc)
 		}
 	}
 
-	backupFilePath := filepath.Join(*backupPath, d.Namespace+"-"+d.Name+time.Now().Format("2006-01-02-15-04-15")+".yaml")
-	backupFile, err := os.Create(backupFilePath)
-
-	if err != nil {
-		log.Printf("Create %s err: %v", backupFilePath, err)
-		os.Exit(1)
-	}
-	defer backupFile.Close()
-
-	_, errW := backupFile.Write(deployYaml)
-	if errW != nil {
-		log.Printf("Write file %s err: %v\n", backupFilePath, errW)
-		os.Exit(1)
+	if _, err := backup.BeforeMutation("update deployment labels", objects...); err != nil {
+		log.Printf("Backup deployment = %s.%s err: %v\n", d.Namespace, d.Name, err)
+		return err
 	}
-
+	return nil
 }
 
 func (d *DeploySpec) NewBackupLogger() *log.Logger {
 	newLog := new(log.Logger)
-	backupPath, err := utils.GetBackupPath()
-	if err != nil {
-		log.Printf("Get backup path err: %v", err)
-		os.Exit(1)
-	}
-
-	backupLogPath := filepath.Join(*backupPath, "ops.log")
-	logFile, err := os.OpenFile(backupLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	logWriter, err := backup.LogWriter("ops.log")
 	if err != nil {
-		log.Panicf("Create logs file = %s err: %v", backupLogPath, err)
+		log.Panicf("Open backup storage for ops.log err: %v", err)
 	}
 
-	multiW := io.MultiWriter(os.Stdout, logFile)
+	multiW := io.MultiWriter(os.Stdout, logWriter)
 	newLog.SetOutput(multiW)
 	newLog.SetFlags(log.LstdFlags)
 	return newLog
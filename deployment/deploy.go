@@ -5,11 +5,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"k8sctl/backup"
+	"k8sctl/preview"
 	"k8sctl/utils"
 	"log"
 	"os"
 	"reflect"
-	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -35,6 +36,22 @@ type DeploySpec struct {
 	RequestMem   string
 	LimitCpu     string
 	LimitMem     string
+	Owner        string
+	TTL          time.Duration
+	// name of the copy in NewNamespace, default Name
+	NewName string
+	// written to k8sctl.io/copy annotation, default NewName
+	CopyName string
+	// type of the copied service, ServiceTypeClusterIP if empty
+	ServiceType string
+	// copy mode, ModeRecreate or ModeApply
+	Mode string
+	// apply mode only, not take over spec.replicas of an existing target
+	KeepTargetReplicas bool
+	// render new ingress hosts, empty means not copy ingress
+	IngressHostTemplate string
+	// override tls secret name of copied ingress
+	IngressTLSSecret string
 }
 
 func NewDeploy(client *kubernetes.Clientset) *DeploySpec {
@@ -49,6 +66,9 @@ func (d *DeploySpec) UpdateLimits() error {
 	if err != nil {
 		return err
 	}
+	if _, err := backup.BeforeMutation("update deployment limits", deploy); err != nil {
+		return err
+	}
 
 	containers := deploy.Spec.Template.Spec.Containers
 	for i := 0; i < len(containers); i++ {
@@ -72,6 +92,9 @@ func (d *DeploySpec) UpdateRequests() error {
 	if err != nil {
 		return err
 	}
+	if _, err := backup.BeforeMutation("update deployment requests", deploy); err != nil {
+		return err
+	}
 
 	containers := deploy.Spec.Template.Spec.Containers
 	for i := 0; i < len(containers); i++ {
@@ -211,7 +234,9 @@ func (d *DeploySpec) UpdateLabel() error {
 	}
 
 	// backup deployment.yaml in $HOME/.kube/k8sctl-backups/
-	d.BackupToLocal()
+	if err := d.BackupToLocal(); err != nil {
+		return err
+	}
 
 	// Create tmp Deployment
 	tmpDeployment := d.createTmpDeploy(oriDeployment)
@@ -219,12 +244,12 @@ func (d *DeploySpec) UpdateLabel() error {
 
 	if tmpDeployment == nil {
 		log.Printf("Create deployment = %s failed.", oriDeployment.Name)
-		os.Exit(1)
+		return fmt.Errorf("create deployment = %s-tmp failed", d.Name)
 	}
 
 	if err := WaitDeploymentUpdate(d.Client, tmpDeployment.Namespace, tmpDeployment.Name, 180); err != nil {
 		log.Printf("Tmp deployment = %s started failed, please check.", tmpDeployment.Name)
-		os.Exit(1)
+		return err
 	}
 
 	// Force update deployment with new labels
@@ -245,20 +270,20 @@ func (d *DeploySpec) UpdateLabel() error {
 	})
 	if err != nil {
 		log.Printf("Delete deployment = %s.%s failed, err = %v", d.Namespace, d.Name, err)
-		os.Exit(1)
+		return err
 	}
 
 	newDeploy = d.addPrestop(newDeploy)
 	if newDeploy == nil {
 		log.Printf("Create newDeployment with preStop err, please check")
-		return nil
+		return fmt.Errorf("add preStop to deployment = %s.%s failed, tmp deployment = %s-tmp is left running", d.Namespace, d.Name, d.Name)
 	}
 
 	time.Sleep(1 * time.Second)
 	_, err1 := d.Client.AppsV1().Deployments(d.Namespace).Create(context.TODO(), newDeploy, metav1.CreateOptions{})
 	if err1 != nil {
 		log.Printf("Force update deployment = %s.%s  labels failed, err = %v", d.Namespace, d.Name, err1)
-		os.Exit(1)
+		return err1
 	}
 
 	errWait := WaitDeploymentUpdate(d.Client, d.Namespace, d.Name, 180)
@@ -281,7 +306,7 @@ func (d *DeploySpec) UpdateLabel() error {
 
 		if err2 != nil {
 			log.Printf("Force update service = %s.%s labels failed, err = %v", d.Namespace, d.Name, err2)
-			os.Exit(1)
+			return err2
 		}
 		log.Printf("标签更新完成 Service = %s ", d.Name)
 
@@ -330,35 +355,82 @@ func (d *DeploySpec) UpdateLabel() error {
 	if err = d.Client.AppsV1().Deployments(d.Namespace).Delete(context.TODO(), fmt.Sprintf("%s-tmp", d.Name), metav1.DeleteOptions{
 		GracePeriodSeconds: &graceTimeout,
 	}); err != nil {
-		log.Panicf("Delete deployment = %s.%s-tmp failed, err = %v", d.Namespace, d.Name, err)
-		os.Exit(1)
+		log.Printf("Delete deployment = %s.%s-tmp failed, err = %v", d.Namespace, d.Name, err)
+		return err
 	}
 	log.Printf("成功删除临时 deployment = %s-tmp\n应用标签替换完成 deployment = %s", d.Name, d.Name)
 
 	return nil
 }
 
-func (d *DeploySpec) CreateNew() error {
-	// copy service
-	log.Println("Copy Service ...")
-	oriService := d.GetSvc(d.Name, d.Namespace)
+// CreateNew copy service and deployment to NewNamespace and wait for it, its hpa, pdb and ingress follow,
+// return the fields changed from the source service
+func (d *DeploySpec) CreateNew() ([]ServiceChange, error) {
+	if d.Namespace == d.NewNamespace && !d.renamed() {
+		return nil, fmt.Errorf("copy deployment = %s to the same namespace = %s need a new name", d.Name, d.Namespace)
+	}
 
-	if oriService == nil {
-		return fmt.Errorf("在命名空间= %s 没有发现服务= %s, 请先部署到命名空间 %s,再重试", d.Namespace, d.Name, d.Namespace)
+	var svcChanges []ServiceChange
+	if d.Mode == ModeApply {
+		changed, changes, err := d.ApplyNew()
+		if err != nil {
+			return nil, err
+		}
+		svcChanges = changes
+		if !changed {
+			log.Printf("Apply deployment and service = %s, namespace = %s, nothing changed.", d.targetName(), d.NewNamespace)
+		}
+	} else {
+		changes, err := d.CopySvc()
+		if err != nil {
+			return nil, err
+		}
+		svcChanges = changes
+
+		if err := d.CopyDeploy(); err != nil {
+			return svcChanges, err
+		}
 	}
-	log.Printf("Service = %s, namespace = %s has found. Continue ...", d.Name, d.Namespace)
 
-	dstService := d.GetSvc(d.Name, d.NewNamespace)
+	// waitfor deployment
+	err := WaitDeploymentUpdate(d.Client, d.NewNamespace, d.targetName(), 180)
 
-	if dstService != nil {
-		log.Printf("Service = %s, namespace = %s has found. Recreating it ...", d.Name, d.NewNamespace)
-		if ok := d.DeleteNewSvc(); !ok {
-			log.Println("Delete service failed")
+	if err != nil {
+		log.Printf("wait for pod running err: %s\n", err)
+		return svcChanges, err
+	}
+	log.Println("Pod running successfully!")
+
+	// the renamed copy get its own hpa and pdb
+	if d.renamed() {
+		if err := d.CopyHPAs(); err != nil {
+			return svcChanges, err
+		}
+		if err := d.CopyPDBs(); err != nil {
+			return svcChanges, err
 		}
 	}
-	d.CreateNewSvc(oriService)
 
-	// copy deployment
+	// copy ingress
+	if d.IngressHostTemplate != "" {
+		log.Println("Copy Ingress ...")
+		hosts, err := d.CopyIngresses()
+		if err != nil {
+			log.Printf("copy ingress err: %s\n", err)
+			return svcChanges, err
+		}
+		for _, h := range hosts {
+			log.Printf("Copied app is available at: %s", h)
+		}
+	}
+
+	return svcChanges, nil
+
+}
+
+// CopyDeploy copy deployment from Namespace to NewNamespace without waiting for it,
+// an existing one in NewNamespace will be recreated
+func (d *DeploySpec) CopyDeploy() error {
 	log.Println("Copy Deployment ...")
 	srcDeploy := d.getDeploy(d.Name, d.Namespace)
 
@@ -367,28 +439,21 @@ func (d *DeploySpec) CreateNew() error {
 	}
 	log.Printf("Deployment = %s, namespace = %s has found. Continue ...", d.Name, d.Namespace)
 
-	dstDeploy := d.getDeploy(d.Name, d.NewNamespace)
+	dstDeploy := d.getDeploy(d.targetName(), d.NewNamespace)
 
 	if dstDeploy != nil {
-		log.Printf("Deployment = %s, namespace = %s has found. Recreating it ...", d.Name, d.NewNamespace)
+		log.Printf("Deployment = %s, namespace = %s has found. Recreating it ...", d.targetName(), d.NewNamespace)
+		if _, err := backup.BeforeMutation("copy deployment", dstDeploy); err != nil {
+			return err
+		}
 		if ok := d.DeleteNewDeploy(); !ok {
 			log.Println("Delete deployment failed")
 		}
 	}
-	d.createNewDeploy(srcDeploy)
-
-	// waitfor deployment
-	err := WaitDeploymentUpdate(d.Client, d.NewNamespace, d.Name, 180)
-
-	if err != nil {
-		log.Printf("wait for pod running err: %s\n", err)
-		return err
-	}
-	log.Println("Pod running successfully!")
-
-	return nil
-
+	_, err := d.createNewDeploy(srcDeploy)
+	return err
 }
+
 func (d *DeploySpec) getDeploy(name, ns string) *appsv1.Deployment {
 
 	deploy, err := d.Client.AppsV1().Deployments(ns).Get(context.TODO(), name, metav1.GetOptions{})
@@ -405,50 +470,83 @@ func (d *DeploySpec) getDeploy(name, ns string) *appsv1.Deployment {
 func (d *DeploySpec) DeleteNewDeploy() bool {
 	dryRun = append(dryRun, "All")
 
-	err := d.Client.AppsV1().Deployments(d.NewNamespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{
+	err := d.Client.AppsV1().Deployments(d.NewNamespace).Delete(context.TODO(), d.targetName(), metav1.DeleteOptions{
 		DryRun: dryRun,
 	})
 
 	if err != nil {
-		log.Printf("Dryrun delete deployment = %s, namespace = %s err: %s\n", d.Name, d.NewNamespace, err)
+		log.Printf("Dryrun delete deployment = %s, namespace = %s err: %s\n", d.targetName(), d.NewNamespace, err)
 		return false
 	}
-	log.Printf("Dryrun delete deployment = %s, namespace = %s successfully.\n", d.Name, d.NewNamespace)
+	log.Printf("Dryrun delete deployment = %s, namespace = %s successfully.\n", d.targetName(), d.NewNamespace)
 
 	var graceTimeout int64 = 40
-	_ = d.Client.AppsV1().Deployments(d.NewNamespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{
+	_ = d.Client.AppsV1().Deployments(d.NewNamespace).Delete(context.TODO(), d.targetName(), metav1.DeleteOptions{
 		GracePeriodSeconds: &graceTimeout,
 	})
-	log.Printf("Delete deployment = %s, namespace = %s successfully.\n", d.Name, d.NewNamespace)
+	log.Printf("Delete deployment = %s, namespace = %s successfully.\n", d.targetName(), d.NewNamespace)
 
 	return true
 
 }
 
-func (d *DeploySpec) createNewDeploy(oriDeploy *appsv1.Deployment) *appsv1.Deployment {
+func (d *DeploySpec) createNewDeploy(oriDeploy *appsv1.Deployment) (*appsv1.Deployment, error) {
+	copied, err := d.copyOfDeploy(oriDeploy)
+	if err != nil {
+		return nil, err
+	}
+	newDeploy, err := d.Client.AppsV1().Deployments(d.NewNamespace).Create(context.TODO(), copied, metav1.CreateOptions{})
+
+	if err != nil {
+		log.Printf("Create deployment = %s, namespace = %s err %s\n", d.targetName(), d.NewNamespace, err)
+		return nil, err
+	}
+	log.Printf("Create deployment = %s, namesapce = %s complete.\n", d.targetName(), d.NewNamespace)
+
+	return newDeploy, nil
+}
 
+// copyOfDeploy build the deployment object to create in NewNamespace
+func (d *DeploySpec) copyOfDeploy(oriDeploy *appsv1.Deployment) (*appsv1.Deployment, error) {
 	oriDeployDeep := oriDeploy.DeepCopy()
 	oriDeployDeep.Namespace = d.NewNamespace
 	oriDeployDeep.ResourceVersion = ""
 	oriDeployDeep.Spec.Replicas = &d.Replicas
+	oriDeployDeep.UID = ""
+	oriDeployDeep.ManagedFields = nil
+	oriDeployDeep.Status = appsv1.DeploymentStatus{}
+	if d.renamed() {
+		if err := d.renameDeploy(oriDeployDeep); err != nil {
+			return nil, err
+		}
+	}
+	d.markCopy(&oriDeployDeep.ObjectMeta)
 
 	if d.ImageTag != "" {
 		image := oriDeployDeep.Spec.Template.Spec.Containers[0].Image
-		s := strings.Split(image, ":")
-		if len(s) == 2 {
-			s[1] = d.ImageTag
-			oriDeployDeep.Spec.Template.Spec.Containers[0].Image = strings.Join(s, ":")
-		}
+		oriDeployDeep.Spec.Template.Spec.Containers[0].Image = utils.ReplaceImageTag(image, d.ImageTag)
 	}
 
-	newDeploy, err := d.Client.AppsV1().Deployments(d.NewNamespace).Create(context.TODO(), oriDeployDeep, metav1.CreateOptions{})
+	return oriDeployDeep, nil
+}
 
-	if err != nil {
-		log.Panicf("Create deployment = %s, namespace = %s err %s\n", d.Name, d.NewNamespace, err)
+// copyName is the name all objects of this copy share, the app for copy app
+func (d *DeploySpec) copyName() string {
+	if d.CopyName != "" {
+		return d.CopyName
 	}
-	log.Printf("Create deployment = %s, namesapce = %s complete.\n", d.Name, d.NewNamespace)
+	return d.targetName()
+}
 
-	return newDeploy
+// markCopy annotate objects created by copy, so gc and delete can find them
+func (d *DeploySpec) markCopy(meta *metav1.ObjectMeta) {
+	m := &preview.Mark{
+		Owner:  d.Owner,
+		Source: d.Namespace + "/" + d.Name,
+		Copy:   d.copyName(),
+		TTL:    d.TTL,
+	}
+	m.Annotate(meta)
 }
 
 func (d *DeploySpec) createTmpDeploy(oriDeploy *appsv1.Deployment) *appsv1.Deployment {
@@ -0,0 +1,177 @@
+package deployment
+
+import (
+	"fmt"
+	"k8sctl/config"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ServiceTypeKeep         = "keep"
+	ServiceTypeClusterIP    = "clusterip"
+	ServiceTypeNodePort     = "nodeport"
+	ServiceTypeLoadBalancer = "loadbalancer"
+)
+
+// cloud load balancer annotations, they often bind the service to one existing lb,
+// so they are dropped unless the serviceAnnotations of k8sctl config say keep
+var lbAnnotationPrefixes = []string{
+	"service.beta.kubernetes.io/",
+	"service.kubernetes.io/",
+	"service.cloud.tencent.com/",
+	"cloud.google.com/",
+	"networking.gke.io/",
+}
+
+// ServiceChange is one field of the source service changed in the copy
+type ServiceChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+func (c ServiceChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.From, c.To)
+}
+
+// exposeSvc set the type of the copied service by ServiceType, return every changed field
+func (d *DeploySpec) exposeSvc(svc *corev1.Service) []ServiceChange {
+	var changes []ServiceChange
+	change := func(field string, from, to any) {
+		changes = append(changes, ServiceChange{Field: field, From: fmt.Sprint(from), To: fmt.Sprint(to)})
+	}
+
+	newType := corev1.ServiceTypeClusterIP
+	switch d.ServiceType {
+	case ServiceTypeKeep:
+		newType = svc.Spec.Type
+	case ServiceTypeNodePort:
+		newType = corev1.ServiceTypeNodePort
+	case ServiceTypeLoadBalancer:
+		newType = corev1.ServiceTypeLoadBalancer
+	}
+	if newType == corev1.ServiceTypeExternalName {
+		newType = svc.Spec.Type
+	}
+	if svc.Spec.Type != newType {
+		change("spec.type", svc.Spec.Type, newType)
+		svc.Spec.Type = newType
+	}
+
+	// headless service stay headless unless it become a NodePort or LoadBalancer, which need a cluster ip
+	headless := svc.Spec.ClusterIP == corev1.ClusterIPNone
+	if svc.Spec.ClusterIP != "" && (!headless || newType != corev1.ServiceTypeClusterIP) {
+		change("spec.clusterIP", svc.Spec.ClusterIP, "<allocated>")
+		svc.Spec.ClusterIP = ""
+		svc.Spec.ClusterIPs = nil
+	}
+	if len(svc.Spec.ExternalIPs) > 0 {
+		change("spec.externalIPs", svc.Spec.ExternalIPs, "<none>")
+		svc.Spec.ExternalIPs = nil
+	}
+
+	exposed := newType == corev1.ServiceTypeNodePort || newType == corev1.ServiceTypeLoadBalancer
+	for k, p := range svc.Spec.Ports {
+		if p.NodePort == 0 {
+			continue
+		}
+		// nodePort is unique in the cluster, a kept NodePort service get fresh ones
+		to := "<allocated>"
+		if !exposed {
+			to = "<none>"
+		}
+		change(fmt.Sprintf("spec.ports[%s].nodePort", portName(p)), p.NodePort, to)
+		svc.Spec.Ports[k].NodePort = 0
+	}
+	if svc.Spec.HealthCheckNodePort != 0 {
+		change("spec.healthCheckNodePort", svc.Spec.HealthCheckNodePort, "<allocated>")
+		svc.Spec.HealthCheckNodePort = 0
+	}
+	if !exposed && svc.Spec.ExternalTrafficPolicy != "" {
+		change("spec.externalTrafficPolicy", svc.Spec.ExternalTrafficPolicy, "<none>")
+		svc.Spec.ExternalTrafficPolicy = ""
+	}
+
+	if newType != corev1.ServiceTypeLoadBalancer {
+		if svc.Spec.LoadBalancerIP != "" {
+			change("spec.loadBalancerIP", svc.Spec.LoadBalancerIP, "<none>")
+		}
+		if len(svc.Spec.LoadBalancerSourceRanges) > 0 {
+			change("spec.loadBalancerSourceRanges", svc.Spec.LoadBalancerSourceRanges, "<none>")
+		}
+		if svc.Spec.LoadBalancerClass != nil {
+			change("spec.loadBalancerClass", *svc.Spec.LoadBalancerClass, "<none>")
+		}
+		svc.Spec.LoadBalancerSourceRanges = nil
+		svc.Spec.LoadBalancerClass = nil
+		svc.Spec.AllocateLoadBalancerNodePorts = nil
+	}
+	// a fixed lb ip belong to the source service
+	if svc.Spec.LoadBalancerIP != "" {
+		if newType == corev1.ServiceTypeLoadBalancer {
+			change("spec.loadBalancerIP", svc.Spec.LoadBalancerIP, "<allocated>")
+		}
+		svc.Spec.LoadBalancerIP = ""
+	}
+
+	changes = append(changes, d.mapLBAnnotations(svc, newType == corev1.ServiceTypeLoadBalancer)...)
+	return changes
+}
+
+// mapLBAnnotations drop cloud lb annotations, a LoadBalancer copy keep those the config mapping say keep
+func (d *DeploySpec) mapLBAnnotations(svc *corev1.Service, lb bool) []ServiceChange {
+	mapping := make(map[string]string)
+	if c, err := config.Load(); err != nil {
+		log.Printf("Load config = %s err: %v, drop every lb annotation", config.Path(), err)
+	} else {
+		mapping = c.ServiceAnnotations
+	}
+
+	var changes []ServiceChange
+	for k := range svc.Annotations {
+		if !isLBAnnotation(k, mapping) {
+			continue
+		}
+		if lb && annotationAction(k, mapping) == "keep" {
+			continue
+		}
+		changes = append(changes, ServiceChange{Field: fmt.Sprintf("metadata.annotations[%s]", k), From: svc.Annotations[k], To: "<dropped>"})
+		delete(svc.Annotations, k)
+	}
+	return changes
+}
+
+func isLBAnnotation(key string, mapping map[string]string) bool {
+	for _, p := range lbAnnotationPrefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	for p := range mapping {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// annotationAction is the action of the longest prefix of key in mapping, default drop
+func annotationAction(key string, mapping map[string]string) string {
+	action, matched := "drop", ""
+	for p, a := range mapping {
+		if strings.HasPrefix(key, p) && len(p) > len(matched) {
+			action, matched = a, p
+		}
+	}
+	return action
+}
+
+func portName(p corev1.ServicePort) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%d", p.Port)
+}
@@ -0,0 +1,51 @@
+package deployment
+
+import (
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestExposeSvcClusterIP(t *testing.T) {
+	t.Setenv("K8SCTL_CONFIG", filepath.Join(t.TempDir(), "k8sctl.yaml"))
+
+	cases := []struct {
+		name        string
+		serviceType string
+		clusterIP   string
+		want        string
+	}{
+		{"headless stay headless", ServiceTypeClusterIP, corev1.ClusterIPNone, corev1.ClusterIPNone},
+		{"headless keep", ServiceTypeKeep, corev1.ClusterIPNone, corev1.ClusterIPNone},
+		{"headless to nodeport", ServiceTypeNodePort, corev1.ClusterIPNone, ""},
+		{"headless to loadbalancer", ServiceTypeLoadBalancer, corev1.ClusterIPNone, ""},
+		{"cluster ip allocated again", ServiceTypeClusterIP, "10.0.0.1", ""},
+	}
+	for _, c := range cases {
+		svc := &corev1.Service{
+			Spec: corev1.ServiceSpec{
+				Type:       corev1.ServiceTypeClusterIP,
+				ClusterIP:  c.clusterIP,
+				ClusterIPs: []string{c.clusterIP},
+			},
+		}
+		d := &DeploySpec{ServiceType: c.serviceType}
+		changes := d.exposeSvc(svc)
+		if svc.Spec.ClusterIP != c.want {
+			t.Errorf("%s: clusterIP = %q, want %q", c.name, svc.Spec.ClusterIP, c.want)
+		}
+		if c.want == "" && svc.Spec.ClusterIPs != nil {
+			t.Errorf("%s: clusterIPs = %v, want cleared", c.name, svc.Spec.ClusterIPs)
+		}
+		reported := false
+		for _, ch := range changes {
+			if ch.Field == "spec.clusterIP" {
+				reported = true
+			}
+		}
+		if reported != (c.want != c.clusterIP) {
+			t.Errorf("%s: clusterIP change reported = %t, changes %v", c.name, reported, changes)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"k8sctl/backup"
+	"k8sctl/preview"
+	"log"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ingressHost is the data of --ingress-host-template
+type ingressHost struct {
+	// deployment name
+	Name string
+	// target namespace
+	Namespace string
+	// host of the source ingress rule
+	Host string
+}
+
+// CopyIngresses clone the ingress rules which route to the source service into NewNamespace,
+// hosts are rendered by IngressHostTemplate, return the new hosts
+func (d *DeploySpec) CopyIngresses() ([]string, error) {
+	tpl, err := template.New("host").Parse(d.IngressHostTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	ingresses, err := d.Client.NetworkingV1().Ingresses(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Printf("List ingress in namespace = %s err: %v", d.Namespace, err)
+		return nil, err
+	}
+
+	var hosts []string
+	for _, ing := range ingresses.Items {
+		newIng, newHosts, err := d.rewriteIngress(&ing, tpl)
+		if err != nil {
+			return nil, err
+		}
+		if newIng == nil {
+			continue
+		}
+
+		for i, tls := range newIng.Spec.TLS {
+			if newIng.Spec.TLS[i].SecretName, err = d.copyTLSSecret(tls.SecretName); err != nil {
+				return nil, err
+			}
+		}
+
+		dst, err := d.Client.NetworkingV1().Ingresses(d.NewNamespace).Get(context.TODO(), newIng.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := preview.RefuseNotCopy("ingress", dst); err != nil {
+				return nil, err
+			}
+			if _, err := backup.BeforeMutation("copy deployment", dst); err != nil {
+				return nil, err
+			}
+			if err := d.Client.NetworkingV1().Ingresses(d.NewNamespace).Delete(context.TODO(), newIng.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				log.Printf("Delete ingress = %s, namespace = %s err: %v", newIng.Name, d.NewNamespace, err)
+				return nil, err
+			}
+		}
+		if _, err := d.Client.NetworkingV1().Ingresses(d.NewNamespace).Create(context.TODO(), newIng, metav1.CreateOptions{}); err != nil {
+			log.Printf("Create ingress = %s, namespace = %s err: %v", newIng.Name, d.NewNamespace, err)
+			return nil, err
+		}
+		log.Printf("Create ingress = %s, namespace = %s complete, hosts = %v", newIng.Name, d.NewNamespace, newHosts)
+		hosts = append(hosts, newHosts...)
+	}
+
+	if len(hosts) == 0 {
+		log.Printf("INFO: not found any ingress route to service = %s, namespace = %s", d.Name, d.Namespace)
+	}
+	return hosts, nil
+}
+
+// rewriteIngress keep only the paths route to d.Name, return nil if nothing left
+func (d *DeploySpec) rewriteIngress(ing *networkingv1.Ingress, tpl *template.Template) (*networkingv1.Ingress, []string, error) {
+	routeToSvc := func(b *networkingv1.IngressBackend) bool {
+		return b != nil && b.Service != nil && b.Service.Name == d.Name
+	}
+
+	// old host -> new host
+	hostMap := make(map[string]string)
+	renderHost := func(host string) (string, error) {
+		if h, ok := hostMap[host]; ok {
+			return h, nil
+		}
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, ingressHost{Name: d.targetName(), Namespace: d.NewNamespace, Host: host}); err != nil {
+			return "", err
+		}
+		hostMap[host] = buf.String()
+		return hostMap[host], nil
+	}
+
+	newIng := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        d.renameObject(ing.Name),
+			Namespace:   d.NewNamespace,
+			Labels:      ing.Labels,
+			Annotations: make(map[string]string),
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+		},
+	}
+	for k, v := range ing.Annotations {
+		newIng.Annotations[k] = v
+	}
+	delete(newIng.Annotations, "kubectl.kubernetes.io/last-applied-configuration")
+
+	if routeToSvc(ing.Spec.DefaultBackend) {
+		newIng.Spec.DefaultBackend = ing.Spec.DefaultBackend.DeepCopy()
+		newIng.Spec.DefaultBackend.Service.Name = d.targetName()
+	}
+
+	var newHosts []string
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		var paths []networkingv1.HTTPIngressPath
+		for _, p := range rule.HTTP.Paths {
+			if routeToSvc(&p.Backend) {
+				newPath := p.DeepCopy()
+				newPath.Backend.Service.Name = d.targetName()
+				paths = append(paths, *newPath)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		host, err := renderHost(rule.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+		newIng.Spec.Rules = append(newIng.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+		newHosts = append(newHosts, host)
+	}
+
+	if len(newIng.Spec.Rules) == 0 && newIng.Spec.DefaultBackend == nil {
+		return nil, nil, nil
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		var tlsHosts []string
+		for _, h := range tls.Hosts {
+			if newHost, ok := hostMap[h]; ok {
+				tlsHosts = append(tlsHosts, newHost)
+			}
+		}
+		if len(tlsHosts) == 0 {
+			continue
+		}
+		secretName := tls.SecretName
+		if d.IngressTLSSecret != "" {
+			secretName = d.IngressTLSSecret
+		}
+		newIng.Spec.TLS = append(newIng.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      tlsHosts,
+			SecretName: secretName,
+		})
+	}
+
+	d.markCopy(&newIng.ObjectMeta)
+	return newIng, newHosts, nil
+}
+
+// copyTLSSecret copy the tls secret from source namespace if target namespace not have its own,
+// the copy is named after the copy and marked like the other objects, so delete and gc remove it
+// without touching the secret of another copy. return the secret name the ingress use
+func (d *DeploySpec) copyTLSSecret(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	own, err := d.Client.CoreV1().Secrets(d.NewNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if _, ok := own.Annotations[preview.AnnotationCopy]; !ok {
+			return name, nil
+		}
+	}
+
+	secret, err := d.Client.CoreV1().Secrets(d.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Get tls secret = %s, namespace = %s err: %v, ingress tls will not work", name, d.Namespace, err)
+		return name, nil
+	}
+
+	newSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-" + d.copyName(),
+			Namespace: d.NewNamespace,
+			Labels:    secret.Labels,
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+	m := &preview.Mark{
+		Owner:  d.Owner,
+		Source: d.Namespace + "/" + name,
+		Copy:   d.copyName(),
+		TTL:    d.TTL,
+	}
+	m.Annotate(&newSecret.ObjectMeta)
+
+	dst, err := d.Client.CoreV1().Secrets(d.NewNamespace).Get(context.TODO(), newSecret.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if err := preview.RefuseNotCopy("secret", dst); err != nil {
+			return "", err
+		}
+		if _, err := backup.BeforeMutation("copy deployment", dst); err != nil {
+			return "", err
+		}
+		if err := d.Client.CoreV1().Secrets(d.NewNamespace).Delete(context.TODO(), newSecret.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			log.Printf("Delete tls secret = %s, namespace = %s err: %v", newSecret.Name, d.NewNamespace, err)
+			return "", err
+		}
+	}
+	if _, err := d.Client.CoreV1().Secrets(d.NewNamespace).Create(context.TODO(), newSecret, metav1.CreateOptions{}); err != nil {
+		log.Printf("Create tls secret = %s, namespace = %s err: %v", newSecret.Name, d.NewNamespace, err)
+		return "", err
+	}
+	log.Printf("Create tls secret = %s, namespace = %s complete.", newSecret.Name, d.NewNamespace)
+	return newSecret.Name, nil
+}
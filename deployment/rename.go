@@ -0,0 +1,310 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/preview"
+	"log"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// targetName is the name of deployment and service in NewNamespace
+func (d *DeploySpec) targetName() string {
+	if d.NewName != "" {
+		return d.NewName
+	}
+	return d.Name
+}
+
+func (d *DeploySpec) renamed() bool {
+	return d.targetName() != d.Name
+}
+
+// renameValue rewrite a label value of the selector, the source name become the new name,
+// any other value get the suffix, e.g. foo -> foo-pr123, stable -> stable-pr123
+func (d *DeploySpec) renameValue(v string) string {
+	if !d.renamed() {
+		return v
+	}
+	if v == d.Name {
+		return d.targetName()
+	}
+	return v + d.renameSuffix()
+}
+
+// renameSuffix is what the new name add to the source name, e.g. foo -> foo-pr123 is -pr123,
+// foo -> foobar is -bar, a separator is added when the new name has none
+func (d *DeploySpec) renameSuffix() string {
+	suffix := d.targetName()
+	if strings.HasPrefix(suffix, d.Name) {
+		suffix = strings.TrimPrefix(suffix, d.Name)
+	}
+	if !strings.HasPrefix(suffix, "-") && !strings.HasPrefix(suffix, ".") && !strings.HasPrefix(suffix, "_") {
+		suffix = "-" + suffix
+	}
+	return suffix
+}
+
+// renameLabels rewrite the values of keys in ls, other labels are kept,
+// a rewritten value which is not a valid label value is an error
+func (d *DeploySpec) renameLabels(ls map[string]string, keys map[string]string) (map[string]string, error) {
+	if ls == nil {
+		return nil, nil
+	}
+	newLabels := make(map[string]string, len(ls))
+	for k, v := range ls {
+		if _, ok := keys[k]; ok {
+			renamed, err := d.renameLabelValue(k, v)
+			if err != nil {
+				return nil, err
+			}
+			v = renamed
+		}
+		newLabels[k] = v
+	}
+	return newLabels, nil
+}
+
+func (d *DeploySpec) renameLabelValue(key, v string) (string, error) {
+	renamed := d.renameValue(v)
+	if errs := validation.IsValidLabelValue(renamed); len(errs) > 0 {
+		return "", fmt.Errorf("label %s = %s renamed to %s for %s is invalid: %s", key, v, renamed, d.targetName(), strings.Join(errs, "; "))
+	}
+	return renamed, nil
+}
+
+// selectorKeys is every label key used to select the pods, from deployment and service selector
+func (d *DeploySpec) selectorKeys(deploy *appsv1.Deployment) map[string]string {
+	keys := make(map[string]string)
+	if deploy != nil && deploy.Spec.Selector != nil {
+		for k, v := range deploy.Spec.Selector.MatchLabels {
+			keys[k] = v
+		}
+	}
+	if svc := d.GetSvc(d.Name, d.Namespace); svc != nil {
+		for k, v := range svc.Spec.Selector {
+			keys[k] = v
+		}
+	}
+	return keys
+}
+
+// renameDeploy give the copy a new name and its own selector, so the copy and
+// the original never select each other's pods
+func (d *DeploySpec) renameDeploy(deploy *appsv1.Deployment) error {
+	keys := d.selectorKeys(deploy)
+	deploy.Name = d.targetName()
+	var err error
+	if deploy.Labels, err = d.renameLabels(deploy.Labels, keys); err != nil {
+		return err
+	}
+	if deploy.Spec.Template.Labels, err = d.renameLabels(deploy.Spec.Template.Labels, keys); err != nil {
+		return err
+	}
+	if deploy.Spec.Selector != nil {
+		if deploy.Spec.Selector.MatchLabels, err = d.renameLabels(deploy.Spec.Selector.MatchLabels, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DeploySpec) renameSvc(svc *corev1.Service) error {
+	keys := d.selectorKeys(d.getDeploy(d.Name, d.Namespace))
+	svc.Name = d.targetName()
+	var err error
+	if svc.Labels, err = d.renameLabels(svc.Labels, keys); err != nil {
+		return err
+	}
+	svc.Spec.Selector, err = d.renameLabels(svc.Spec.Selector, keys)
+	return err
+}
+
+// renameSelector rewrite a pdb selector of the source pods to select the pods of the copy:
+// every template label renamed in the copy is rewritten in matchLabels and matchExpressions,
+// and added to matchLabels when the selector does not use it, so the original pods never match
+func (d *DeploySpec) renameSelector(sel *metav1.LabelSelector, keys map[string]string, podLabels map[string]string) (*metav1.LabelSelector, error) {
+	newSel := sel.DeepCopy()
+	if !d.renamed() {
+		return newSel, nil
+	}
+	var err error
+	if newSel.MatchLabels, err = d.renameLabels(newSel.MatchLabels, keys); err != nil {
+		return nil, err
+	}
+	// keys which already select only the renamed values
+	selected := make(map[string]bool)
+	for k := range newSel.MatchLabels {
+		selected[k] = true
+	}
+	for i, e := range newSel.MatchExpressions {
+		if _, ok := keys[e.Key]; !ok {
+			continue
+		}
+		if e.Operator != metav1.LabelSelectorOpIn && e.Operator != metav1.LabelSelectorOpNotIn {
+			continue
+		}
+		for j, v := range e.Values {
+			if newSel.MatchExpressions[i].Values[j], err = d.renameLabelValue(e.Key, v); err != nil {
+				return nil, err
+			}
+		}
+		if e.Operator == metav1.LabelSelectorOpIn {
+			selected[e.Key] = true
+		}
+	}
+	for k := range keys {
+		v, ok := podLabels[k]
+		if !ok || selected[k] {
+			continue
+		}
+		if newSel.MatchLabels == nil {
+			newSel.MatchLabels = make(map[string]string)
+		}
+		if newSel.MatchLabels[k], err = d.renameLabelValue(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return newSel, nil
+}
+
+// renameObject is the name of hpa, pdb or ingress of the copy
+func (d *DeploySpec) renameObject(name string) string {
+	if name == d.Name {
+		return d.targetName()
+	}
+	return d.renameValue(name)
+}
+
+// CopyHPAs copy hpa which scale the source deployment, scaleTargetRef point to the renamed copy
+func (d *DeploySpec) CopyHPAs() error {
+	hpas, err := d.Client.AutoscalingV2().HorizontalPodAutoscalers(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Printf("List hpa in namespace = %s err: %v", d.Namespace, err)
+		return err
+	}
+
+	for _, hpa := range hpas.Items {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind != "Deployment" || ref.Name != d.Name {
+			continue
+		}
+
+		newHpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      d.renameObject(hpa.Name),
+				Namespace: d.NewNamespace,
+				Labels:    hpa.Labels,
+			},
+			Spec: *hpa.Spec.DeepCopy(),
+		}
+		newHpa.Spec.ScaleTargetRef.Name = d.targetName()
+		d.markCopy(&newHpa.ObjectMeta)
+
+		dst, err := d.Client.AutoscalingV2().HorizontalPodAutoscalers(d.NewNamespace).Get(context.TODO(), newHpa.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			if err := preview.RefuseNotCopy("hpa", dst); err != nil {
+				return err
+			}
+			if _, err := backup.BeforeMutation("copy deployment", dst); err != nil {
+				return err
+			}
+			if err := d.Client.AutoscalingV2().HorizontalPodAutoscalers(d.NewNamespace).Delete(context.TODO(), newHpa.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				log.Printf("Delete hpa = %s, namespace = %s err: %v", newHpa.Name, d.NewNamespace, err)
+				return err
+			}
+		}
+		if _, err := d.Client.AutoscalingV2().HorizontalPodAutoscalers(d.NewNamespace).Create(context.TODO(), newHpa, metav1.CreateOptions{}); err != nil {
+			log.Printf("Create hpa = %s, namespace = %s err: %v", newHpa.Name, d.NewNamespace, err)
+			return err
+		}
+		log.Printf("Create hpa = %s, namespace = %s complete, target = %s.", newHpa.Name, d.NewNamespace, d.targetName())
+	}
+	return nil
+}
+
+// CopyPDBs copy pdb which select the source pods, the selector is rewritten like the deployment's
+func (d *DeploySpec) CopyPDBs() error {
+	src := d.getDeploy(d.Name, d.Namespace)
+	if src == nil {
+		return nil
+	}
+	keys := d.selectorKeys(src)
+	podLabels := labels.Set(src.Spec.Template.Labels)
+
+	pdbs, err := d.Client.PolicyV1().PodDisruptionBudgets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Printf("List pdb in namespace = %s err: %v", d.Namespace, err)
+		return err
+	}
+
+	for _, pdb := range pdbs.Items {
+		if pdb.Spec.Selector == nil {
+			log.Printf("WARN: pdb = %s, namespace = %s has no selector, skip it", pdb.Name, d.Namespace)
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			log.Printf("WARN: pdb = %s, namespace = %s selector is invalid, skip it: %v", pdb.Name, d.Namespace, err)
+			continue
+		}
+		if selector.Empty() {
+			log.Printf("WARN: pdb = %s, namespace = %s select every pod of the namespace, skip it", pdb.Name, d.Namespace)
+			continue
+		}
+		if !selector.Matches(podLabels) {
+			continue
+		}
+		newSelector, err := d.renameSelector(pdb.Spec.Selector, keys, src.Spec.Template.Labels)
+		if err != nil {
+			return err
+		}
+
+		newPdb := &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      d.renameObject(pdb.Name),
+				Namespace: d.NewNamespace,
+				Labels:    pdb.Labels,
+			},
+			Spec: *pdb.Spec.DeepCopy(),
+		}
+		newPdb.Spec.Selector = newSelector
+		d.markCopy(&newPdb.ObjectMeta)
+
+		dst, err := d.Client.PolicyV1().PodDisruptionBudgets(d.NewNamespace).Get(context.TODO(), newPdb.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			if err := preview.RefuseNotCopy("pdb", dst); err != nil {
+				return err
+			}
+			if _, err := backup.BeforeMutation("copy deployment", dst); err != nil {
+				return err
+			}
+			if err := d.Client.PolicyV1().PodDisruptionBudgets(d.NewNamespace).Delete(context.TODO(), newPdb.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				log.Printf("Delete pdb = %s, namespace = %s err: %v", newPdb.Name, d.NewNamespace, err)
+				return err
+			}
+		}
+		if _, err := d.Client.PolicyV1().PodDisruptionBudgets(d.NewNamespace).Create(context.TODO(), newPdb, metav1.CreateOptions{}); err != nil {
+			log.Printf("Create pdb = %s, namespace = %s err: %v", newPdb.Name, d.NewNamespace, err)
+			return err
+		}
+		log.Printf("Create pdb = %s, namespace = %s complete.", newPdb.Name, d.NewNamespace)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package deployment
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenameValue(t *testing.T) {
+	cases := []struct {
+		name, newName string
+		value, want   string
+	}{
+		{"web", "web-pr123", "web", "web-pr123"},
+		{"web", "web-pr123", "stable", "stable-pr123"},
+		{"web", "web-pr123", "web-api", "web-api-pr123"},
+		// the new name not start with the old one, the whole new name become the suffix
+		{"web", "preview", "web", "preview"},
+		{"web", "preview", "stable", "stable-preview"},
+		// the new name add no separator, one is added to the other values
+		{"web", "webbar", "web", "webbar"},
+		{"web", "webbar", "stable", "stable-bar"},
+		{"web", "web.pr1", "stable", "stable.pr1"},
+		// not renamed
+		{"web", "", "web", "web"},
+	}
+	for _, c := range cases {
+		d := &DeploySpec{Name: c.name, NewName: c.newName}
+		if got := d.renameValue(c.value); got != c.want {
+			t.Errorf("renameValue(%q) with %s -> %s = %q, want %q", c.value, c.name, c.newName, got, c.want)
+		}
+	}
+}
+
+func TestRenameLabels(t *testing.T) {
+	d := &DeploySpec{Name: "web", NewName: "web-pr1"}
+	got, err := d.renameLabels(
+		map[string]string{"app": "web", "track": "stable", "team": "a"},
+		map[string]string{"app": "", "track": ""},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app": "web-pr1", "track": "stable-pr1", "team": "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, _ := d.renameLabels(nil, map[string]string{"app": ""}); got != nil {
+		t.Errorf("nil labels should stay nil")
+	}
+
+	// the renamed value is longer than 63 characters
+	if _, err := d.renameLabels(map[string]string{"track": strings.Repeat("a", 60)}, map[string]string{"track": ""}); err == nil {
+		t.Errorf("want an error for a label value too long")
+	}
+}
+
+func TestRenameSelector(t *testing.T) {
+	d := &DeploySpec{Name: "web", NewName: "web-pr1"}
+	keys := map[string]string{"app": ""}
+	podLabels := map[string]string{"app": "web", "team": "a"}
+
+	tests := []struct {
+		name string
+		sel  *metav1.LabelSelector
+		want *metav1.LabelSelector
+	}{
+		{
+			name: "renamed key",
+			sel:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			want: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web-pr1"}},
+		},
+		{
+			name: "other template label",
+			sel:  &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			want: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a", "app": "web-pr1"}},
+		},
+		{
+			name: "match expressions",
+			sel: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}},
+				{Key: "team", Operator: metav1.LabelSelectorOpExists},
+			}},
+			want: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web-pr1"}},
+				{Key: "team", Operator: metav1.LabelSelectorOpExists},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.renameSelector(tt.sel, keys, podLabels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
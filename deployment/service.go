@@ -2,6 +2,8 @@ package deployment
 
 import (
 	"context"
+	"fmt"
+	"k8sctl/backup"
 	"log"
 
 	corev1 "k8s.io/api/core/v1"
@@ -23,44 +25,88 @@ func (d *DeploySpec) GetSvc(name, ns string) *corev1.Service {
 func (d *DeploySpec) DeleteNewSvc() bool {
 	dryRun = append(dryRun, "All")
 
-	err := d.Client.CoreV1().Services(d.NewNamespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{
+	err := d.Client.CoreV1().Services(d.NewNamespace).Delete(context.TODO(), d.targetName(), metav1.DeleteOptions{
 		DryRun: dryRun,
 	})
 	if err != nil {
-		log.Printf("DryRun delete svc = %s, namespace = %s error: %s\n", d.NewNamespace, d.Name, err)
+		log.Printf("DryRun delete svc = %s, namespace = %s error: %s\n", d.NewNamespace, d.targetName(), err)
 		return false
 	}
 
-	log.Printf("DryRun delete svc = %s, namespace = %s successfully.\n", d.Name, d.NewNamespace)
-	_ = d.Client.CoreV1().Services(d.NewNamespace).Delete(context.TODO(), d.Name, metav1.DeleteOptions{})
+	log.Printf("DryRun delete svc = %s, namespace = %s successfully.\n", d.targetName(), d.NewNamespace)
+	_ = d.Client.CoreV1().Services(d.NewNamespace).Delete(context.TODO(), d.targetName(), metav1.DeleteOptions{})
 
-	log.Printf("Delete svc = %s, namespace = %s successfully.\n", d.Name, d.NewNamespace)
+	log.Printf("Delete svc = %s, namespace = %s successfully.\n", d.targetName(), d.NewNamespace)
 	return true
 }
 
-func (d *DeploySpec) CreateNewSvc(oriService *corev1.Service) *corev1.Service {
+// CopySvc copy service from Namespace to NewNamespace, an existing one in NewNamespace will be recreated,
+// return the fields changed from the source service
+func (d *DeploySpec) CopySvc() ([]ServiceChange, error) {
+	log.Println("Copy Service ...")
+	oriService := d.GetSvc(d.Name, d.Namespace)
 
-	oriServiceDeep := oriService.DeepCopy()
-	oriServiceDeep.Namespace = d.NewNamespace
-	oriServiceDeep.Spec.Type = corev1.ServiceTypeClusterIP
-	oriServiceDeep.ResourceVersion = ""
-	oriServiceDeep.Spec.ExternalTrafficPolicy = ""
-	oriServiceDeep.Spec.ClusterIP = ""
-	oriServiceDeep.Spec.ClusterIPs = nil
+	if oriService == nil {
+		return nil, fmt.Errorf("在命名空间= %s 没有发现服务= %s, 请先部署到命名空间 %s,再重试", d.Namespace, d.Name, d.Namespace)
+	}
+	log.Printf("Service = %s, namespace = %s has found. Continue ...", d.Name, d.Namespace)
+
+	dstService := d.GetSvc(d.targetName(), d.NewNamespace)
 
-	for k, v := range oriServiceDeep.Spec.Ports {
-		if v.NodePort != 0 {
-			oriServiceDeep.Spec.Ports[k].NodePort = 0
+	if dstService != nil {
+		log.Printf("Service = %s, namespace = %s has found. Recreating it ...", d.targetName(), d.NewNamespace)
+		if _, err := backup.BeforeMutation("copy deployment", dstService); err != nil {
+			return nil, err
+		}
+		if ok := d.DeleteNewSvc(); !ok {
+			log.Println("Delete service failed")
 		}
 	}
+	_, changes, err := d.createNewSvc(oriService)
+	return changes, err
+}
 
-	newSvc, err := d.Client.CoreV1().Services(d.NewNamespace).Create(context.TODO(), oriServiceDeep, metav1.CreateOptions{})
+func (d *DeploySpec) CreateNewSvc(oriService *corev1.Service) *corev1.Service {
+	newSvc, _, err := d.createNewSvc(oriService)
 	if err != nil {
-		log.Panicf("Create service = %s, namespace = %s err %s\n", d.Name, d.NewNamespace, err)
+		log.Panicf("Create service = %s, namespace = %s err %s\n", d.targetName(), d.NewNamespace, err)
 	}
+	return newSvc
+}
 
-	log.Printf("Create service = %s, namespace = %s complete.\n", d.Name, d.NewNamespace)
+func (d *DeploySpec) createNewSvc(oriService *corev1.Service) (*corev1.Service, []ServiceChange, error) {
+	copied, changes, err := d.copyOfSvc(oriService)
+	if err != nil {
+		return nil, nil, err
+	}
+	newSvc, err := d.Client.CoreV1().Services(d.NewNamespace).Create(context.TODO(), copied, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Create service = %s, namespace = %s err %s\n", d.targetName(), d.NewNamespace, err)
+		return nil, nil, err
+	}
 
-	return newSvc
+	log.Printf("Create service = %s, namespace = %s complete.\n", d.targetName(), d.NewNamespace)
+
+	return newSvc, changes, nil
+
+}
+
+// copyOfSvc build the service object to create in NewNamespace and the fields changed from the source
+func (d *DeploySpec) copyOfSvc(oriService *corev1.Service) (*corev1.Service, []ServiceChange, error) {
+	oriServiceDeep := oriService.DeepCopy()
+	oriServiceDeep.Namespace = d.NewNamespace
+	oriServiceDeep.ResourceVersion = ""
+	oriServiceDeep.UID = ""
+	oriServiceDeep.ManagedFields = nil
+	oriServiceDeep.Status = corev1.ServiceStatus{}
+
+	changes := d.exposeSvc(oriServiceDeep)
+	if d.renamed() {
+		if err := d.renameSvc(oriServiceDeep); err != nil {
+			return nil, nil, err
+		}
+	}
+	d.markCopy(&oriServiceDeep.ObjectMeta)
 
+	return oriServiceDeep, changes, nil
 }
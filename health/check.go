@@ -0,0 +1,343 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindJob         = "Job"
+
+	// statefulset rollout not finished, currentRevision != updateRevision
+	ReasonRevisionMismatch = "RevisionMismatch"
+	// daemonset pods running on nodes they should not
+	ReasonMisscheduled = "Misscheduled"
+	// daemonset nodes without an available pod
+	ReasonUnavailable = "Unavailable"
+	// job exceeded activeDeadlineSeconds
+	ReasonDeadlineExceeded = "DeadlineExceeded"
+	// job failed, e.g. backoffLimit exceeded
+	ReasonJobFailed = "JobFailed"
+
+	// default RestartThreshold
+	DefaultRestartThreshold = 30
+	// default JobsWithin, a job failed a day ago is usually retried or replaced by the next run of its cronjob
+	DefaultJobsWithin = 24 * time.Hour
+)
+
+// Check find unhealthy controllers and their unhealthy pods
+type Check struct {
+	Client *kubernetes.Clientset
+	// namespace to check, "" or all for every namespace
+	Namespace string
+	// label selector of controllers
+	Selector string
+	// namespace glob patterns, like team-*, include empty means every namespace
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	// a not ready container restarted more than it is unhealthy
+	RestartThreshold int32
+	// only restarts and OOMKilled within it count, 0 means any time.
+	// the kubelet keep no restart history, so a container restarted more than RestartThreshold times
+	// in its life is unhealthy when its last restart is within it, not only when every restart is
+	RestartsWithin time.Duration
+	// only jobs failed within it count, 0 means any time
+	JobsWithin time.Duration
+	// pods younger than it are still starting and skipped
+	MinAge time.Duration
+	// latest events attached to every finding, 0 means no events
+	EventLimit int
+}
+
+// Run check the controllers of kind, all for every supported kind
+func (c *Check) Run(kind string) ([]Unhealthy, error) {
+	checks := map[string]func(time.Time) ([]Unhealthy, error){
+		KindDeployment:  c.Deployments,
+		KindStatefulSet: c.StatefulSets,
+		KindDaemonSet:   c.DaemonSets,
+		KindJob:         c.Jobs,
+	}
+	if kind != "all" {
+		check, ok := checks[kind]
+		if !ok {
+			return nil, fmt.Errorf("unsupported kind %s", kind)
+		}
+		found, err := check(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		c.attachEvents(found)
+		return found, nil
+	}
+
+	var all []Unhealthy
+	for _, k := range []string{KindDeployment, KindStatefulSet, KindDaemonSet, KindJob} {
+		found, err := checks[k](time.Now())
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, found...)
+	}
+	c.attachEvents(all)
+	return all, nil
+}
+
+func (c *Check) namespace() string {
+	if c.Namespace == "all" {
+		return metav1.NamespaceAll
+	}
+	return c.Namespace
+}
+
+func (c *Check) listOptions() metav1.ListOptions {
+	return metav1.ListOptions{
+		ResourceVersion: "0",
+		LabelSelector:   c.Selector,
+	}
+}
+
+func (c *Check) namespaceMatch(ns string) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, ns); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.IncludeNamespaces) > 0 && !match(c.IncludeNamespaces) {
+		return false
+	}
+	return !match(c.ExcludeNamespaces)
+}
+
+// starting report whether the pod is younger than MinAge
+func (c *Check) starting(p *corev1.Pod, now time.Time) bool {
+	return c.MinAge > 0 && now.Sub(p.CreationTimestamp.Time) < c.MinAge
+}
+
+// pods diagnose the pods of a controller selected by selector
+func (c *Check) pods(kind, name, ns string, selector *metav1.LabelSelector, now time.Time) ([]Unhealthy, error) {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.Client.CoreV1().Pods(ns).List(context.Background(), metav1.ListOptions{
+		LabelSelector: s.String(),
+	})
+	if err != nil {
+		log.Printf("List pod ns = %s, %s = %s, error: %v", ns, kind, name, err)
+		return nil, err
+	}
+
+	var found []Unhealthy
+	for _, p := range pods.Items {
+		if c.starting(&p, now) {
+			continue
+		}
+		u, ok := c.diagnosePod(&p, now)
+		if !ok {
+			continue
+		}
+		u.Kind, u.Controller = kind, name
+		for _, ref := range p.OwnerReferences {
+			if ref.Kind != kind || ref.Name != name {
+				u.owners = append(u.owners, ref.Kind+"/"+ref.Name)
+			}
+		}
+		found = append(found, u)
+	}
+	return found, nil
+}
+
+// Deployments report the pods of deployments not fully available
+func (c *Check) Deployments(now time.Time) ([]Unhealthy, error) {
+	ds, err := c.Client.AppsV1().Deployments(c.namespace()).List(context.Background(), c.listOptions())
+	// if ns not found, it will return 200, and print "no deploy found on ns"
+	if err != nil {
+		log.Printf("Get unhealthy deployment with ns = %s error: %v", c.Namespace, err)
+		return nil, err
+	}
+
+	var found []Unhealthy
+	for _, deploy := range ds.Items {
+		if !c.namespaceMatch(deploy.Namespace) {
+			continue
+		}
+
+		dStat := deploy.Status
+		if dStat.AvailableReplicas == dStat.Replicas &&
+			dStat.ReadyReplicas == dStat.Replicas &&
+			dStat.UpdatedReplicas == dStat.Replicas {
+			continue
+		}
+		pods, err := c.pods(KindDeployment, deploy.Name, deploy.Namespace, deploy.Spec.Selector, now)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, pods...)
+	}
+	return found, nil
+}
+
+// StatefulSets report statefulsets whose rollout is not finished, and the pods of statefulsets not fully ready
+func (c *Check) StatefulSets(now time.Time) ([]Unhealthy, error) {
+	list, err := c.Client.AppsV1().StatefulSets(c.namespace()).List(context.Background(), c.listOptions())
+	if err != nil {
+		log.Printf("Get unhealthy statefulset with ns = %s error: %v", c.Namespace, err)
+		return nil, err
+	}
+
+	var found []Unhealthy
+	for _, sts := range list.Items {
+		if !c.namespaceMatch(sts.Namespace) {
+			continue
+		}
+
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		st := sts.Status
+		if st.UpdateRevision != "" && st.CurrentRevision != st.UpdateRevision {
+			found = append(found, Unhealthy{
+				Namespace:  sts.Namespace,
+				Kind:       KindStatefulSet,
+				Controller: sts.Name,
+				Reason:     ReasonRevisionMismatch,
+				Message: fmt.Sprintf("currentRevision %s, updateRevision %s, updated %d/%d",
+					st.CurrentRevision, st.UpdateRevision, st.UpdatedReplicas, replicas),
+			})
+		}
+		if st.ReadyReplicas == replicas && st.AvailableReplicas == replicas {
+			continue
+		}
+		pods, err := c.pods(KindStatefulSet, sts.Name, sts.Namespace, sts.Spec.Selector, now)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, pods...)
+	}
+	return found, nil
+}
+
+// DaemonSets report daemonsets with misscheduled or unavailable pods, and their unhealthy pods
+func (c *Check) DaemonSets(now time.Time) ([]Unhealthy, error) {
+	list, err := c.Client.AppsV1().DaemonSets(c.namespace()).List(context.Background(), c.listOptions())
+	if err != nil {
+		log.Printf("Get unhealthy daemonset with ns = %s error: %v", c.Namespace, err)
+		return nil, err
+	}
+
+	var found []Unhealthy
+	for _, ds := range list.Items {
+		if !c.namespaceMatch(ds.Namespace) {
+			continue
+		}
+
+		st := ds.Status
+		if st.NumberMisscheduled > 0 {
+			found = append(found, Unhealthy{
+				Namespace:  ds.Namespace,
+				Kind:       KindDaemonSet,
+				Controller: ds.Name,
+				Reason:     ReasonMisscheduled,
+				Message:    fmt.Sprintf("%d pods run on nodes they should not", st.NumberMisscheduled),
+			})
+		}
+		if st.NumberUnavailable == 0 && st.UpdatedNumberScheduled == st.DesiredNumberScheduled {
+			continue
+		}
+		pods, err := c.pods(KindDaemonSet, ds.Name, ds.Namespace, ds.Spec.Selector, now)
+		if err != nil {
+			return nil, err
+		}
+		// unavailable nodes without a pod to blame, e.g. the pod is not created yet
+		if st.NumberUnavailable > 0 && len(pods) == 0 {
+			found = append(found, Unhealthy{
+				Namespace:  ds.Namespace,
+				Kind:       KindDaemonSet,
+				Controller: ds.Name,
+				Reason:     ReasonUnavailable,
+				Message: fmt.Sprintf("%d of %d nodes have no available pod, updated %d",
+					st.NumberUnavailable, st.DesiredNumberScheduled, st.UpdatedNumberScheduled),
+			})
+		}
+		found = append(found, pods...)
+	}
+	return found, nil
+}
+
+// Jobs report failed jobs and jobs exceeded activeDeadlineSeconds, and the unhealthy pods of running jobs
+func (c *Check) Jobs(now time.Time) ([]Unhealthy, error) {
+	list, err := c.Client.BatchV1().Jobs(c.namespace()).List(context.Background(), c.listOptions())
+	if err != nil {
+		log.Printf("Get unhealthy job with ns = %s error: %v", c.Namespace, err)
+		return nil, err
+	}
+
+	var found []Unhealthy
+	for _, job := range list.Items {
+		if !c.namespaceMatch(job.Namespace) {
+			continue
+		}
+
+		if failed, ok := c.failedJob(&job, now); ok {
+			if failed != nil {
+				found = append(found, *failed)
+			}
+			continue
+		}
+		if job.Status.Active == 0 {
+			continue
+		}
+		pods, err := c.pods(KindJob, job.Name, job.Namespace, job.Spec.Selector, now)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, pods...)
+	}
+	return found, nil
+}
+
+// failedJob report whether job has failed, the finding is nil for a job failed before JobsWithin
+func (c *Check) failedJob(job *batchv1.Job, now time.Time) (*Unhealthy, bool) {
+	cond := failedCondition(job)
+	if cond == nil {
+		return nil, false
+	}
+	if c.JobsWithin > 0 && now.Sub(cond.LastTransitionTime.Time) > c.JobsWithin {
+		return nil, true
+	}
+	reason := ReasonJobFailed
+	if cond.Reason == ReasonDeadlineExceeded {
+		reason = ReasonDeadlineExceeded
+	}
+	return &Unhealthy{
+		Namespace:  job.Namespace,
+		Kind:       KindJob,
+		Controller: job.Name,
+		Reason:     reason,
+		Message:    fmt.Sprintf("%s: %s, failed pods %d", cond.Reason, cond.Message, job.Status.Failed),
+	}, true
+}
+
+func failedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFailedJob(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	job := func(reason string, age time.Duration, status corev1.ConditionStatus) *batchv1.Job {
+		j := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "dev"}}
+		if reason != "" {
+			j.Status.Conditions = []batchv1.JobCondition{{
+				Type:               batchv1.JobFailed,
+				Status:             status,
+				Reason:             reason,
+				LastTransitionTime: metav1.NewTime(now.Add(-age)),
+			}}
+		}
+		return j
+	}
+
+	tests := []struct {
+		name       string
+		job        *batchv1.Job
+		jobsWithin time.Duration
+		// restarts-within must not hide failed jobs
+		restartsWithin time.Duration
+		wantFailed     bool
+		wantReason     string
+	}{
+		{name: "running", job: job("", 0, ""), jobsWithin: DefaultJobsWithin},
+		{name: "condition false", job: job("BackoffLimitExceeded", time.Hour, corev1.ConditionFalse), jobsWithin: DefaultJobsWithin},
+		{name: "failed recently", job: job("BackoffLimitExceeded", time.Hour, corev1.ConditionTrue), jobsWithin: DefaultJobsWithin,
+			restartsWithin: time.Minute, wantFailed: true, wantReason: ReasonJobFailed},
+		{name: "deadline exceeded", job: job(ReasonDeadlineExceeded, time.Hour, corev1.ConditionTrue), jobsWithin: DefaultJobsWithin,
+			wantFailed: true, wantReason: ReasonDeadlineExceeded},
+		{name: "failed before window", job: job("BackoffLimitExceeded", 48*time.Hour, corev1.ConditionTrue), jobsWithin: DefaultJobsWithin,
+			wantFailed: true},
+		{name: "any time", job: job("BackoffLimitExceeded", 48*time.Hour, corev1.ConditionTrue),
+			wantFailed: true, wantReason: ReasonJobFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Check{JobsWithin: tt.jobsWithin, RestartsWithin: tt.restartsWithin}
+			got, failed := c.failedJob(tt.job, now)
+			if failed != tt.wantFailed {
+				t.Fatalf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+			reason := ""
+			if got != nil {
+				reason = got.Reason
+			}
+			if reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNamespaceMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		ns      string
+		want    bool
+	}{
+		{name: "no patterns", ns: "dev", want: true},
+		{name: "included", include: []string{"team-*"}, ns: "team-a", want: true},
+		{name: "not included", include: []string{"team-*"}, ns: "dev"},
+		{name: "excluded", exclude: []string{"kube-*"}, ns: "kube-system"},
+		{name: "not excluded", exclude: []string{"kube-*"}, ns: "dev", want: true},
+		{name: "excluded over included", include: []string{"team-*"}, exclude: []string{"team-b"}, ns: "team-b"},
+		{name: "any include", include: []string{"dev", "team-*"}, ns: "dev", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Check{IncludeNamespaces: tt.include, ExcludeNamespaces: tt.exclude}
+			if got := c.namespaceMatch(tt.ns); got != tt.want {
+				t.Errorf("namespaceMatch(%s) = %v, want %v", tt.ns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecent(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		within time.Duration
+		t      time.Time
+		want   bool
+	}{
+		{name: "any time", t: now.Add(-48 * time.Hour), want: true},
+		{name: "any time unknown", want: true},
+		{name: "within", within: time.Hour, t: now.Add(-time.Minute), want: true},
+		{name: "at the edge", within: time.Hour, t: now.Add(-time.Hour), want: true},
+		{name: "too old", within: time.Hour, t: now.Add(-2 * time.Hour)},
+		{name: "unknown", within: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Check{RestartsWithin: tt.within}
+			if got := c.recent(tt.t, now); got != tt.want {
+				t.Errorf("recent = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStarting(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	pod := func(age time.Duration) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+	}
+	tests := []struct {
+		name   string
+		minAge time.Duration
+		pod    *corev1.Pod
+		want   bool
+	}{
+		{name: "no min age", pod: pod(0)},
+		{name: "younger", minAge: 5 * time.Minute, pod: pod(time.Minute), want: true},
+		{name: "older", minAge: 5 * time.Minute, pod: pod(10 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Check{MinAge: tt.minAge}
+			if got := c.starting(tt.pod, now); got != tt.want {
+				t.Errorf("starting = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
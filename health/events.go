@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultEventLimit is the default number of events attached to every finding
+const DefaultEventLimit = 5
+
+// Event is one deduplicated event of a flagged pod or its controllers, like kubectl describe shows
+type Event struct {
+	// Kind/name of the involved object
+	Object   string    `json:"object"`
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s %s: %s", e.Object, e.Reason, e.Message)
+	if e.Count > 1 {
+		s += fmt.Sprintf(" (x%d)", e.Count)
+	}
+	return s
+}
+
+// attachEvents add the latest EventLimit events of the pod, its owners and the controller to every finding.
+// events are only extra context, a namespace whose events can not be listed, e.g. by rbac, is reported without them
+func (c *Check) attachEvents(found []Unhealthy) {
+	if c.EventLimit <= 0 {
+		return
+	}
+	cache := map[string][]corev1.Event{}
+	for i := range found {
+		u := &found[i]
+		events, ok := cache[u.Namespace]
+		if !ok {
+			list, err := c.Client.CoreV1().Events(u.Namespace).List(context.Background(), metav1.ListOptions{ResourceVersion: "0"})
+			if err != nil {
+				log.Printf("WARN: list events ns = %s error: %v, continue without events", u.Namespace, err)
+			} else {
+				events = list.Items
+			}
+			cache[u.Namespace] = events
+		}
+		u.Events = latestEvents(events, u.involved(), c.EventLimit)
+	}
+}
+
+// involved return Kind/name of the pod, its owners like the replicaset, and the controller
+func (u *Unhealthy) involved() []string {
+	var objs []string
+	if u.Pod != "" {
+		objs = append(objs, "Pod/"+u.Pod)
+	}
+	objs = append(objs, u.owners...)
+	return append(objs, u.Kind+"/"+u.Controller)
+}
+
+// latestEvents dedup the events of objects by reason and message, newest first
+func latestEvents(events []corev1.Event, objects []string, limit int) []Event {
+	involved := map[string]bool{}
+	for _, o := range objects {
+		involved[o] = true
+	}
+
+	byKey := map[string]*Event{}
+	var deduped []*Event
+	for _, e := range events {
+		obj := e.InvolvedObject.Kind + "/" + e.InvolvedObject.Name
+		if !involved[obj] {
+			continue
+		}
+		count := e.Count
+		if e.Series != nil && e.Series.Count > count {
+			count = e.Series.Count
+		}
+		if count == 0 {
+			count = 1
+		}
+		seen := eventTime(&e)
+
+		message := strings.TrimSpace(e.Message)
+		key := obj + "\x00" + e.Reason + "\x00" + message
+		if d, ok := byKey[key]; ok {
+			d.Count += count
+			if seen.After(d.LastSeen) {
+				d.LastSeen = seen
+			}
+			continue
+		}
+		d := &Event{Object: obj, Type: e.Type, Reason: e.Reason, Message: message, Count: count, LastSeen: seen}
+		byKey[key] = d
+		deduped = append(deduped, d)
+	}
+
+	sort.SliceStable(deduped, func(i, j int) bool {
+		return deduped[i].LastSeen.After(deduped[j].LastSeen)
+	})
+	if len(deduped) > limit {
+		deduped = deduped[:limit]
+	}
+	var latest []Event
+	for _, d := range deduped {
+		latest = append(latest, *d)
+	}
+	return latest
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
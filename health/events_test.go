@@ -0,0 +1,67 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLatestEvents(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	event := func(kind, name, reason, message string, count int32, age time.Duration) corev1.Event {
+		return corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: kind, Name: name},
+			Type:           corev1.EventTypeWarning,
+			Reason:         reason,
+			Message:        message,
+			Count:          count,
+			LastTimestamp:  metav1.NewTime(now.Add(-age)),
+		}
+	}
+	events := []corev1.Event{
+		event("Pod", "api-1", "BackOff", "Back-off restarting failed container", 3, 10*time.Minute),
+		event("Pod", "api-1", "BackOff", "Back-off restarting failed container ", 2, time.Minute),
+		event("Pod", "api-1", "Unhealthy", "Readiness probe failed", 1, 5*time.Minute),
+		event("ReplicaSet", "api-5d4f", "FailedCreate", "exceeded quota", 0, 20*time.Minute),
+		event("Deployment", "api", "ScalingReplicaSet", "Scaled up", 1, time.Hour),
+		event("Pod", "web-1", "BackOff", "Back-off restarting failed container", 1, 0),
+	}
+	involved := []string{"Pod/api-1", "ReplicaSet/api-5d4f", "Deployment/api"}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "dedup and newest first",
+			limit: DefaultEventLimit,
+			want: []string{
+				"Pod/api-1 BackOff: Back-off restarting failed container (x5)",
+				"Pod/api-1 Unhealthy: Readiness probe failed",
+				"ReplicaSet/api-5d4f FailedCreate: exceeded quota",
+				"Deployment/api ScalingReplicaSet: Scaled up",
+			},
+		},
+		{
+			name:  "limit",
+			limit: 1,
+			want:  []string{"Pod/api-1 BackOff: Back-off restarting failed container (x5)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := latestEvents(events, involved, tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("event %d = %q, want %q", i, got[i].String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
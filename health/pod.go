@@ -0,0 +1,123 @@
+package health
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// reasons of an unhealthy pod, so ci can tell a bad image from an app crash from no capacity
+const (
+	ReasonImagePull     = "ImagePullBackOff"
+	ReasonConfig        = "CreateContainerConfigError"
+	ReasonOOMKilled     = "OOMKilled"
+	ReasonCrashLoop     = "CrashLoopBackOff"
+	ReasonUnschedulable = "Unschedulable"
+	ReasonPending       = "Pending"
+	// not ready and restarted more than RestartThreshold times
+	ReasonRestarting = "Restarting"
+)
+
+// Unhealthy is one unhealthy pod, or a problem of the controller itself when Pod is empty
+type Unhealthy struct {
+	Namespace string `json:"namespace"`
+	// Deployment, StatefulSet, DaemonSet or Job
+	Kind       string `json:"kind"`
+	Controller string `json:"controller"`
+	Pod        string `json:"pod,omitempty"`
+	Node       string `json:"node,omitempty"`
+	Container  string `json:"container,omitempty"`
+	Reason     string `json:"reason"`
+	Message    string `json:"message,omitempty"`
+	Restarts   int32  `json:"restarts"`
+	// latest events of the pod and its controllers, see Check.EventLimit
+	Events []Event `json:"events,omitempty"`
+
+	// Kind/name of the pod owners, e.g. the replicaset between the pod and the deployment
+	owners []string
+}
+
+// recent report whether the termination is within RestartsWithin
+func (c *Check) recent(t time.Time, now time.Time) bool {
+	if c.RestartsWithin <= 0 {
+		return true
+	}
+	return !t.IsZero() && now.Sub(t) <= c.RestartsWithin
+}
+
+// diagnosePod classify a pod by its scheduling condition, container state and last termination state
+func (c *Check) diagnosePod(p *corev1.Pod, now time.Time) (Unhealthy, bool) {
+	u := Unhealthy{
+		Namespace: p.Namespace,
+		Pod:       p.Name,
+		Node:      p.Spec.NodeName,
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...), p.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		u.Container = s.Name
+		u.Restarts = s.RestartCount
+
+		if w := s.State.Waiting; w != nil {
+			switch w.Reason {
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName", "ErrImageNeverPull":
+				u.Reason, u.Message = ReasonImagePull, w.Message
+				return u, true
+			case "CreateContainerConfigError", "CreateContainerError":
+				u.Reason, u.Message = ReasonConfig, w.Message
+				return u, true
+			case "CrashLoopBackOff":
+				u.Reason, u.Message = ReasonCrashLoop, w.Message
+				if t := s.LastTerminationState.Terminated; t != nil {
+					if t.Reason == ReasonOOMKilled {
+						u.Reason = ReasonOOMKilled
+					}
+					u.Message = terminatedMessage(t)
+				}
+				return u, true
+			}
+		}
+		if t := s.State.Terminated; t != nil && t.Reason == ReasonOOMKilled {
+			u.Reason, u.Message = ReasonOOMKilled, terminatedMessage(t)
+			return u, true
+		}
+		last := s.LastTerminationState.Terminated
+		if last != nil && !c.recent(last.FinishedAt.Time, now) {
+			continue
+		}
+		if last != nil && last.Reason == ReasonOOMKilled && !s.Ready {
+			u.Reason, u.Message = ReasonOOMKilled, terminatedMessage(last)
+			return u, true
+		}
+		// RestartCount is of the container life, the window only limit when the last restart happen
+		if !s.Ready && s.RestartCount > c.RestartThreshold {
+			u.Reason = ReasonRestarting
+			if last != nil {
+				u.Message = terminatedMessage(last)
+			}
+			return u, true
+		}
+	}
+
+	u.Container, u.Restarts = "", 0
+	if p.Status.Phase == corev1.PodPending {
+		for _, cond := range p.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+				u.Reason, u.Message = ReasonUnschedulable, cond.Message
+				return u, true
+			}
+		}
+		u.Reason, u.Message = ReasonPending, p.Status.Message
+		return u, true
+	}
+	return u, false
+}
+
+func terminatedMessage(t *corev1.ContainerStateTerminated) string {
+	msg := fmt.Sprintf("exit code %d, reason %s", t.ExitCode, t.Reason)
+	if t.Message != "" {
+		msg += ": " + t.Message
+	}
+	return msg
+}
@@ -0,0 +1,175 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiagnosePod(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: reason + " message"}}
+	}
+	terminated := func(reason string, code int32, age time.Duration) corev1.ContainerState {
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Reason:     reason,
+			ExitCode:   code,
+			FinishedAt: metav1.NewTime(now.Add(-age)),
+		}}
+	}
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	pod := func(phase corev1.PodPhase, statuses ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "api-1"},
+			Status:     corev1.PodStatus{Phase: phase, ContainerStatuses: statuses},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		want        string
+		wantMessage string
+	}{
+		{
+			name: "image pull",
+			pod:  pod(corev1.PodPending, corev1.ContainerStatus{Name: "app", State: waiting("ErrImagePull")}),
+			want: ReasonImagePull,
+		},
+		{
+			name: "config",
+			pod:  pod(corev1.PodPending, corev1.ContainerStatus{Name: "app", State: waiting("CreateContainerConfigError")}),
+			want: ReasonConfig,
+		},
+		{
+			name: "crash loop",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                waiting("CrashLoopBackOff"),
+				LastTerminationState: terminated("Error", 1, time.Minute),
+				RestartCount:         4,
+			}),
+			want:        ReasonCrashLoop,
+			wantMessage: "exit code 1, reason Error",
+		},
+		{
+			name: "crash loop oom killed",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                waiting("CrashLoopBackOff"),
+				LastTerminationState: terminated(ReasonOOMKilled, 137, time.Minute),
+				RestartCount:         4,
+			}),
+			want: ReasonOOMKilled,
+		},
+		{
+			name: "oom killed state",
+			pod:  pod(corev1.PodRunning, corev1.ContainerStatus{Name: "app", State: terminated(ReasonOOMKilled, 137, 0)}),
+			want: ReasonOOMKilled,
+		},
+		{
+			name: "oom killed last termination not ready",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				LastTerminationState: terminated(ReasonOOMKilled, 137, time.Minute),
+				RestartCount:         1,
+			}),
+			want: ReasonOOMKilled,
+		},
+		{
+			name: "oom killed last termination ready",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				Ready:                true,
+				LastTerminationState: terminated(ReasonOOMKilled, 137, time.Minute),
+				RestartCount:         1,
+			}),
+		},
+		{
+			name: "restarting",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				LastTerminationState: terminated("Error", 2, time.Minute),
+				RestartCount:         6,
+			}),
+			want:        ReasonRestarting,
+			wantMessage: "exit code 2, reason Error",
+		},
+		{
+			name: "restarts under threshold",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				LastTerminationState: terminated("Error", 2, time.Minute),
+				RestartCount:         5,
+			}),
+		},
+		{
+			name: "restarting ready",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				Ready:                true,
+				LastTerminationState: terminated("Error", 2, time.Minute),
+				RestartCount:         6,
+			}),
+		},
+		{
+			name: "restarted long ago",
+			pod: pod(corev1.PodRunning, corev1.ContainerStatus{
+				Name:                 "app",
+				State:                running,
+				LastTerminationState: terminated("Error", 2, 48*time.Hour),
+				RestartCount:         6,
+			}),
+		},
+		{
+			name: "unschedulable",
+			pod: &corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodScheduled,
+					Status:  corev1.ConditionFalse,
+					Message: "0/3 nodes are available: 3 Insufficient cpu.",
+				}},
+			}},
+			want:        ReasonUnschedulable,
+			wantMessage: "0/3 nodes are available: 3 Insufficient cpu.",
+		},
+		{
+			name: "pending",
+			pod:  pod(corev1.PodPending, corev1.ContainerStatus{Name: "app", State: waiting("ContainerCreating")}),
+			want: ReasonPending,
+		},
+		{
+			name: "init container",
+			pod: &corev1.Pod{Status: corev1.PodStatus{
+				Phase:                 corev1.PodPending,
+				InitContainerStatuses: []corev1.ContainerStatus{{Name: "migrate", State: waiting("CrashLoopBackOff")}},
+			}},
+			want: ReasonCrashLoop,
+		},
+		{
+			name: "healthy",
+			pod:  pod(corev1.PodRunning, corev1.ContainerStatus{Name: "app", State: running, Ready: true}),
+		},
+	}
+	c := &Check{RestartThreshold: 5, RestartsWithin: time.Hour}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, unhealthy := c.diagnosePod(tt.pod, now)
+			if unhealthy != (tt.want != "") || u.Reason != tt.want {
+				t.Fatalf("diagnosePod = %s, %v, want %q", u.Reason, unhealthy, tt.want)
+			}
+			if tt.wantMessage != "" && u.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", u.Message, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -2,14 +2,31 @@ package main
 
 import (
 	"fmt"
+	"k8sctl/audit"
+	"k8sctl/backup"
+	"k8sctl/bundle"
 	"k8sctl/cronjob"
 	"k8sctl/deployment"
+	"k8sctl/health"
+	"k8sctl/manifest"
+	"k8sctl/namespace"
+	"k8sctl/preview"
+	"k8sctl/printer"
+	"k8sctl/statefulset"
 	"log"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	k8scrdClient "github.com/changqings/k8scrd/client"
 	"github.com/urfave/cli/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/homedir"
 )
 
 func main() {
@@ -19,6 +36,27 @@ func main() {
 		Usage:                "used for ci_cd pipeline",
 		EnableBashCompletion: true,
 		Version:              "v0.2.0",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "state-dir",
+				Usage:    "where backups and the audit log are kept, each cluster and context get a subdirectory, take precedence over backup.storage.dir, default ~/.kube/k8sctl-backups",
+				EnvVars:  []string{"K8SCTL_HOME"},
+				Required: false,
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			if ctx.IsSet("state-dir") {
+				return os.Setenv("K8SCTL_HOME", ctx.String("state-dir"))
+			}
+			return nil
+		},
+		After: func(ctx *cli.Context) error {
+			// ops.log on s3 is uploaded once per run
+			if err := backup.FlushLogs(); err != nil {
+				log.Printf("Upload ops.log err: %v", err)
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "get",
@@ -28,39 +66,34 @@ func main() {
 						Name:    "deployment",
 						Aliases: []string{"deploy"},
 						Usage:   "get deployment unhealthy pod",
-						Flags: []cli.Flag{
-							&cli.StringFlag{
-								Name:     "namespace",
-								Aliases:  []string{"ns"},
-								Usage:    `deployment namespace,  if not set or ns=all will use check all namespace`,
-								Required: false,
-							},
-						},
-						Action: func(ctx *cli.Context) error {
-
-							ns := ctx.String("namespace")
-							cs, err := k8scrdClient.NewClient()
-							if err != nil {
-								return err
-							}
-
-							if ns == "" || ns == "all" {
-								ns = "all"
-							}
-							m, err := deployment.GetUnhealthyPods(cs.KubeClient, ns)
-							if err != nil {
-								return err
-							}
-
-							if len(m) == 0 {
-								slog.Info("not found any unhealthy pod of your cluster", "ns", ns)
-								return nil
-							}
-							for k, v := range m {
-								slog.Info("unhealthy pod", "namespace", ns, "pod_name", k, "restart_times", v)
-							}
-							return nil
-						},
+						Flags:   healthFlags("deployments"),
+						Action:  healthAction(health.KindDeployment),
+					},
+					{
+						Name:    "statefulset",
+						Aliases: []string{"sts"},
+						Usage:   "get statefulset not rolled out and unhealthy pod",
+						Flags:   healthFlags("statefulsets"),
+						Action:  healthAction(health.KindStatefulSet),
+					},
+					{
+						Name:    "daemonset",
+						Aliases: []string{"ds"},
+						Usage:   "get daemonset misscheduled, unavailable and unhealthy pod",
+						Flags:   healthFlags("daemonsets"),
+						Action:  healthAction(health.KindDaemonSet),
+					},
+					{
+						Name:   "job",
+						Usage:  "get job failed, deadline exceeded and unhealthy pod",
+						Flags:  healthFlags("jobs"),
+						Action: healthAction(health.KindJob),
+					},
+					{
+						Name:   "all",
+						Usage:  "get unhealthy deployment, statefulset, daemonset and job",
+						Flags:  healthFlags("controllers"),
+						Action: healthAction("all"),
 					},
 				},
 			},
@@ -103,27 +136,353 @@ func main() {
 								Usage:    "from namespace",
 								Required: true,
 							},
+							&cli.DurationFlag{
+								Name:     "ttl",
+								Usage:    "copy expires after ttl, then `k8sctl gc previews` will delete it, e.g. 72h",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "owner",
+								Usage:    "owner of the copy, written to k8sctl.io/owner annotation",
+								EnvVars:  []string{"GITLAB_USER_LOGIN", "USER"},
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "create-namespace",
+								Usage:    "create the to namespace from namespaceTemplate of k8sctl config if not exist",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "ingress-host-template",
+								Usage:    "clone ingress route to the service with new host, e.g. \"{{.Name}}-{{.Namespace}}.preview.example.com\", {{.Host}} is the source host",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "ingress-tls-secret",
+								Usage:    "tls secret name of cloned ingress, default copy the source tls secret",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "mode",
+								Usage:    "recreate: delete target if exist then create, apply: server-side apply with field manager k8sctl, fields owned by other managers fail as conflicts, replicas of an existing target is kept unless --replicas set",
+								Value:    deployment.ModeRecreate,
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "new-name",
+								Usage:    "name of the copied deployment and service, selector labels are rewritten so it can live with the original in one namespace",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "suffix",
+								Usage:    "same as --new-name=<name>-<suffix>",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "service-type",
+								Usage:    "type of copied service, keep|clusterip|nodeport|loadbalancer, nodePorts are always allocated again",
+								Value:    deployment.ServiceTypeClusterIP,
+								Required: false,
+							},
 						},
-						Action: func(ctx *cli.Context) error {
+						Action: audited("copy deployment", "Deployment", func(ctx *cli.Context) error {
 							fmt.Printf("Copy deployment and service: %s from: %s to: %s %s\n", ctx.String("name"), ctx.String("from"), ctx.String("to"), ctx.String("tag"))
+							mode := ctx.String("mode")
+							if mode != deployment.ModeRecreate && mode != deployment.ModeApply {
+								return fmt.Errorf("--mode = %s not match recreate|apply", mode)
+							}
+							switch ctx.String("service-type") {
+							case deployment.ServiceTypeKeep, deployment.ServiceTypeClusterIP, deployment.ServiceTypeNodePort, deployment.ServiceTypeLoadBalancer:
+							default:
+								return fmt.Errorf("--service-type = %s not match keep|clusterip|nodeport|loadbalancer", ctx.String("service-type"))
+							}
+							newName := ctx.String("new-name")
+							if ctx.String("suffix") != "" {
+								if newName != "" {
+									return fmt.Errorf("--new-name and --suffix can not be used together")
+								}
+								newName = ctx.String("name") + "-" + ctx.String("suffix")
+							}
 							client, err := k8scrdClient.NewClient()
 							if err != nil {
 								log.Printf("NewClient get err: %v", err)
+								return err
 							}
 							d := &deployment.DeploySpec{
+								Client:              client.KubeClient,
+								Name:                ctx.String("name"),
+								Namespace:           ctx.String("from"),
+								NewNamespace:        ctx.String("to"),
+								ImageTag:            ctx.String("tag"),
+								Replicas:            int32(ctx.Int("replicas")),
+								Owner:               ctx.String("owner"),
+								TTL:                 ctx.Duration("ttl"),
+								IngressHostTemplate: ctx.String("ingress-host-template"),
+								IngressTLSSecret:    ctx.String("ingress-tls-secret"),
+								NewName:             newName,
+								ServiceType:         ctx.String("service-type"),
+								Mode:                mode,
+								KeepTargetReplicas:  !ctx.IsSet("replicas"),
+							}
+							if err := ensureNamespace(ctx, client.KubeClient); err != nil {
+								return err
+							}
+							changes, err := d.CreateNew()
+							if len(changes) > 0 {
+								fmt.Println()
+								w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+								fmt.Fprintln(w, "SERVICE FIELD\tFROM\tTO")
+								for _, c := range changes {
+									fmt.Fprintf(w, "%s\t%s\t%s\n", c.Field, c.From, c.To)
+								}
+								w.Flush()
+							}
+							if err != nil {
+								log.Printf("create new deploy  get err: %v", err)
+								return err
+							}
+							return nil
+						}),
+					},
+					{
+						Name:    "cronjob",
+						Aliases: []string{"cron"},
+						Usage:   "copy cronjob from one namespace to another",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "tag",
+								Usage:    "image tag of new cronjob",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "cronjob name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Aliases:  []string{"t"},
+								Usage:    "to namespace",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "from",
+								Aliases:  []string{"f"},
+								Usage:    "from namespace",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:     "suspend",
+								Usage:    "create the new cronjob suspended",
+								Required: false,
+							},
+							&cli.DurationFlag{
+								Name:     "ttl",
+								Usage:    "copy expires after ttl, then `k8sctl gc previews` will delete it, e.g. 72h",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "owner",
+								Usage:    "owner of the copy, written to k8sctl.io/owner annotation",
+								EnvVars:  []string{"GITLAB_USER_LOGIN", "USER"},
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "create-namespace",
+								Usage:    "create the to namespace from namespaceTemplate of k8sctl config if not exist",
+								Required: false,
+							},
+						},
+						Action: audited("copy cronjob", "CronJob", func(ctx *cli.Context) error {
+							fmt.Printf("Copy cronjob: %s from: %s to: %s %s\n", ctx.String("name"), ctx.String("from"), ctx.String("to"), ctx.String("tag"))
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							c := &cronjob.CronJob{
+								Client:       client.KubeClient,
+								Name:         ctx.String("name"),
+								Namespace:    ctx.String("from"),
+								NewNamespace: ctx.String("to"),
+								ImageTag:     ctx.String("tag"),
+								Suspend:      ctx.Bool("suspend"),
+								Owner:        ctx.String("owner"),
+								TTL:          ctx.Duration("ttl"),
+							}
+							if err := ensureNamespace(ctx, client.KubeClient); err != nil {
+								return err
+							}
+							if err := c.CreateNew(); err != nil {
+								log.Printf("create new cronjob get err: %v", err)
+								return err
+							}
+							return nil
+						}),
+					},
+					{
+						Name:    "statefulset",
+						Aliases: []string{"sts"},
+						Usage:   "copy statefulset from one namespace to another",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "replicas",
+								Usage:    "pod's num",
+								Value:    "1",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "tag",
+								Usage:    "image tag of new statefulset",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "statefulset name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Aliases:  []string{"t"},
+								Usage:    "to namespace",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "from",
+								Aliases:  []string{"f"},
+								Usage:    "from namespace",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:     "ttl",
+								Usage:    "copy expires after ttl, then `k8sctl gc previews` will delete it, e.g. 72h",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "owner",
+								Usage:    "owner of the copy, written to k8sctl.io/owner annotation",
+								EnvVars:  []string{"GITLAB_USER_LOGIN", "USER"},
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "create-namespace",
+								Usage:    "create the to namespace from namespaceTemplate of k8sctl config if not exist",
+								Required: false,
+							},
+						},
+						Action: audited("copy statefulset", "StatefulSet", func(ctx *cli.Context) error {
+							fmt.Printf("Copy statefulset and service: %s from: %s to: %s %s\n", ctx.String("name"), ctx.String("from"), ctx.String("to"), ctx.String("tag"))
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							s := &statefulset.StatefulSetSpec{
 								Client:       client.KubeClient,
 								Name:         ctx.String("name"),
 								Namespace:    ctx.String("from"),
 								NewNamespace: ctx.String("to"),
 								ImageTag:     ctx.String("tag"),
 								Replicas:     int32(ctx.Int("replicas")),
+								Owner:        ctx.String("owner"),
+								TTL:          ctx.Duration("ttl"),
 							}
-							if err := d.CreateNew(); err != nil {
-								log.Printf("create new deploy  get err: %v", err)
+							if err := ensureNamespace(ctx, client.KubeClient); err != nil {
+								return err
+							}
+							if err := s.CreateNew(); err != nil {
+								log.Printf("create new statefulset get err: %v", err)
 								return err
 							}
 							return nil
+						}),
+					},
+					{
+						Name:  "app",
+						Usage: "copy every deployment, service, statefulset, cronjob and configmap with the label from one namespace to another",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "selector",
+								Aliases:  []string{"l"},
+								Usage:    "label selector of the app, e.g. -l app=foo",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "replicas",
+								Usage:    "pod's num of every workload",
+								Value:    "1",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "tag",
+								Usage:    "image tag of new workloads",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Aliases:  []string{"t"},
+								Usage:    "to namespace",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "from",
+								Aliases:  []string{"f"},
+								Usage:    "from namespace",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:     "ttl",
+								Usage:    "copy expires after ttl, then `k8sctl gc previews` will delete it, e.g. 72h",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "owner",
+								Usage:    "owner of the copy, written to k8sctl.io/owner annotation",
+								EnvVars:  []string{"GITLAB_USER_LOGIN", "USER"},
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "create-namespace",
+								Usage:    "create the to namespace from namespaceTemplate of k8sctl config if not exist",
+								Required: false,
+							},
 						},
+						Action: audited("copy app", "", func(ctx *cli.Context) error {
+							fmt.Printf("Copy app: %s from: %s to: %s %s\n", ctx.String("selector"), ctx.String("from"), ctx.String("to"), ctx.String("tag"))
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							b := &bundle.Bundle{
+								Client:       client.KubeClient,
+								Selector:     ctx.String("selector"),
+								Namespace:    ctx.String("from"),
+								NewNamespace: ctx.String("to"),
+								ImageTag:     ctx.String("tag"),
+								Replicas:     int32(ctx.Int("replicas")),
+								Owner:        ctx.String("owner"),
+								TTL:          ctx.Duration("ttl"),
+							}
+							if err := ensureNamespace(ctx, client.KubeClient); err != nil {
+								return err
+							}
+							results, err := b.Copy()
+
+							if len(results) > 0 {
+								fmt.Println()
+								w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+								fmt.Fprintln(w, "KIND\tNAME\tSTATUS\tERROR")
+								for _, r := range results {
+									msg := ""
+									if r.Err != nil {
+										msg = r.Err.Error()
+									}
+									fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Kind, r.Name, r.Status, msg)
+								}
+								w.Flush()
+							}
+							return err
+						}),
 					},
 				},
 			},
@@ -174,7 +533,7 @@ func main() {
 								Required: false,
 							},
 						},
-						Action: func(ctx *cli.Context) error {
+						Action: audited("update cronjob", "CronJob", func(ctx *cli.Context) error {
 							client, err := k8scrdClient.NewClient()
 							if err != nil {
 								return err
@@ -189,8 +548,7 @@ func main() {
 								App:       ctx.String("app"),
 							}
 							if c.Type == "api" {
-								fmt.Println(`你输入的 --type 等于 "api" 会关联主服务 serivce,请检查!!`)
-								os.Exit(1)
+								return fmt.Errorf(`你输入的 --type 等于 "api" 会关联主服务 serivce,请检查!!`)
 							}
 							if ctx.String("autocheck") == "y" || ctx.String("autocheck") == "Y" {
 								c.Confirm = "true"
@@ -201,7 +559,7 @@ func main() {
 								return err
 							}
 							return nil
-						},
+						}),
 					},
 					{
 						Name:    "deployment",
@@ -277,7 +635,7 @@ func main() {
 								Required: false,
 							},
 						},
-						Action: func(ctx *cli.Context) error {
+						Action: audited("update deployment", "Deployment", func(ctx *cli.Context) error {
 							client, err := k8scrdClient.NewClient()
 							if err != nil {
 								return err
@@ -314,8 +672,7 @@ func main() {
 							}
 
 							if d.Type != "api" && d.Type != "script" && d.Type != "fe" {
-								fmt.Println(`你输入的 --type 或 -t 不匹配 "api|script|fe",请检查与 --time 的区别!!`)
-								os.Exit(1)
+								return fmt.Errorf(`你输入的 --type 或 -t 不匹配 "api|script|fe",请检查与 --time 的区别!!`)
 							}
 							if ctx.String("autocheck") == "y" || ctx.String("autocheck") == "Y" {
 								d.Confirm = "true"
@@ -326,19 +683,18 @@ func main() {
 								return err
 							}
 							return nil
-						},
+						}),
 					},
 				},
 			},
 			{
-				Name:    "delete",
-				Usage:   "delete k8s resources",
-				Aliases: []string{"del"},
+				Name:  "export",
+				Usage: "export k8s resources as clean manifests for gitops, runtime fields and defaulted values are stripped",
 				Subcommands: []*cli.Command{
 					{
 						Name:    "deployment",
 						Aliases: []string{"deploy"},
-						Usage:   "del deployment",
+						Usage:   "export deployment and the service with the same name",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
 								Name:     "name",
@@ -352,35 +708,982 @@ func main() {
 								Usage:    "namespace",
 								Required: true,
 							},
+							&cli.StringFlag{
+								Name:     "dir",
+								Aliases:  []string{"d"},
+								Usage:    "write manifests into dir, default print to stdout",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "split",
+								Usage:    "one file per kind, need --dir",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "kustomize",
+								Usage:    "emit kustomization.yaml with resources and images, need --dir",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "image-tag-var",
+								Usage:    "replace image tags with ${VAR}, e.g. --image-tag-var IMAGE_TAG",
+								Required: false,
+							},
 						},
 						Action: func(ctx *cli.Context) error {
 							client, err := k8scrdClient.NewClient()
 							if err != nil {
 								return err
 							}
-							d := &deployment.DeploySpec{
-								Client:       client.KubeClient,
-								Name:         ctx.String("name"),
-								NewNamespace: ctx.String("namespace"),
-							}
-							if ok := d.DeleteNewDeploy(); !ok {
-								log.Printf("Delete deployment %s on %s failed\n", d.Name, d.NewNamespace)
-								return nil
+							objs, err := manifest.FetchDeployment(client.KubeClient, ctx.String("namespace"), ctx.String("name"))
+							if err != nil {
+								return err
 							}
-							if ok := d.DeleteNewSvc(); !ok {
-								log.Printf("Delete service %s on %s failed\n", d.Name, d.NewNamespace)
-								return nil
+							e := &manifest.Export{
+								Dir:         ctx.String("dir"),
+								Out:         os.Stdout,
+								Split:       ctx.Bool("split"),
+								Kustomize:   ctx.Bool("kustomize"),
+								ImageTagVar: ctx.String("image-tag-var"),
 							}
-							return nil
+							return e.Write(objs)
 						},
 					},
-				},
-			},
-		},
-	}
-	app.EnableBashCompletion = true
-	err := app.Run(os.Args)
+					{
+						Name:  "app",
+						Usage: "export every deployment, service, statefulset, cronjob, configmap, ingress, hpa and pdb with the label",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "selector",
+								Aliases:  []string{"l"},
+								Usage:    "label selector of the app, e.g. -l app=foo",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"ns"},
+								Usage:    "namespace",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "dir",
+								Aliases:  []string{"d"},
+								Usage:    "write manifests into dir, default print to stdout",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "split",
+								Usage:    "one file per kind, need --dir",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "kustomize",
+								Usage:    "emit kustomization.yaml with resources and images, need --dir",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "image-tag-var",
+								Usage:    "replace image tags with ${VAR}, e.g. --image-tag-var IMAGE_TAG",
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							objs, err := manifest.FetchApp(client.KubeClient, ctx.String("namespace"), ctx.String("selector"))
+							if err != nil {
+								return err
+							}
+							if len(objs) == 0 {
+								return fmt.Errorf("not found any object with %s in namespace = %s", ctx.String("selector"), ctx.String("namespace"))
+							}
+							e := &manifest.Export{
+								Dir:         ctx.String("dir"),
+								Out:         os.Stdout,
+								Split:       ctx.Bool("split"),
+								Kustomize:   ctx.Bool("kustomize"),
+								ImageTagVar: ctx.String("image-tag-var"),
+							}
+							return e.Write(objs)
+						},
+					},
+				},
+			},
+			{
+				Name:  "backup",
+				Usage: "k8sctl backups catalog",
+				Subcommands: []*cli.Command{
+					{
+						Name:    "list",
+						Aliases: []string{"ls"},
+						Usage:   "list backups",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "object name contains",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"ns"},
+								Usage:    "namespace",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "since",
+								Usage:    "backups newer than a duration like 24h, or a date like 2006-01-02",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "table|json|yaml|wide|markdown",
+								Value:    printer.Table,
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							p, err := printer.New(ctx.String("output"), os.Stdout)
+							if err != nil {
+								return err
+							}
+							since, err := backup.ParseSince(ctx.String("since"))
+							if err != nil {
+								return err
+							}
+							entries, err := backup.Search(backup.Query{
+								Name:      ctx.String("name"),
+								Namespace: ctx.String("namespace"),
+								Since:     since,
+							})
+							if err != nil {
+								return err
+							}
+							return printBackups(p, entries)
+						},
+					},
+					{
+						Name:  "search",
+						Usage: "search backups by object, namespace, operation and time",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "object name contains",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"ns"},
+								Usage:    "namespace",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "since",
+								Usage:    "backups newer than a duration like 24h, or a date like 2006-01-02",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "operation",
+								Aliases:  []string{"op"},
+								Usage:    "operation, e.g. \"update deployment labels\"",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "table|json|yaml|wide|markdown",
+								Value:    printer.Table,
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							if !ctx.IsSet("name") && !ctx.IsSet("namespace") && !ctx.IsSet("since") && !ctx.IsSet("operation") {
+								return fmt.Errorf("search need at least one of --name --namespace --since --operation")
+							}
+							p, err := printer.New(ctx.String("output"), os.Stdout)
+							if err != nil {
+								return err
+							}
+							since, err := backup.ParseSince(ctx.String("since"))
+							if err != nil {
+								return err
+							}
+							entries, err := backup.Search(backup.Query{
+								Name:      ctx.String("name"),
+								Namespace: ctx.String("namespace"),
+								Operation: ctx.String("operation"),
+								Since:     since,
+							})
+							if err != nil {
+								return err
+							}
+							return printBackups(p, entries)
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "delete backups by backup.retention of k8sctl config, the latest backup of every object is kept",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:     "dry-run",
+								Usage:    "only print backups to delete",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "table|json|yaml|wide|markdown",
+								Value:    printer.Table,
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							p, err := printer.New(ctx.String("output"), os.Stdout)
+							if err != nil {
+								return err
+							}
+							pruned, err := backup.Prune(ctx.Bool("dry-run"))
+							if err != nil {
+								return err
+							}
+							if len(pruned) == 0 && p.Human() {
+								slog.Info("nothing to prune")
+								return nil
+							}
+							if ctx.Bool("dry-run") {
+								log.Printf("Dry run, %d backups to prune are not deleted", len(pruned))
+							}
+							return printBackups(p, pruned)
+						},
+					},
+					{
+						Name:      "show",
+						Usage:     "show a backup and its content",
+						ArgsUsage: "<backup-id>",
+						Action: func(ctx *cli.Context) error {
+							if ctx.NArg() != 1 {
+								return fmt.Errorf("usage: k8sctl backup show <backup-id>")
+							}
+							e, data, err := backup.Show(ctx.Args().First())
+							if e == nil {
+								return err
+							}
+							fmt.Printf("id:        %s\n", e.ID)
+							fmt.Printf("time:      %s\n", e.Time.Format(time.RFC3339))
+							fmt.Printf("operation: %s\n", e.Operation)
+							fmt.Printf("user:      %s\n", e.User)
+							fmt.Printf("context:   %s\n", e.Context)
+							fmt.Printf("file:      %s\n", backup.Path(e))
+							fmt.Printf("checksum:  %s\n", e.Checksum)
+							fmt.Println("objects:")
+							for _, o := range e.Objects {
+								fmt.Printf("  - %s %s/%s\n", o.Kind, o.Namespace, o.Name)
+							}
+							if err != nil {
+								return err
+							}
+							if backup.IsSnapshot(data) {
+								return nil
+							}
+							fmt.Println("---")
+							fmt.Print(string(data))
+							return nil
+						},
+					},
+					{
+						Name:  "namespace",
+						Usage: "snapshot deployments, services, cronjobs, statefulsets, configmaps, ingresses, hpas, pdbs and secrets of a namespace into a tar.gz",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"n", "ns"},
+								Usage:    "namespace",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:     "redact-secrets",
+								Usage:    "write only the keys of secrets, not the values, even when backup.recipient is set",
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							e, err := backup.SnapshotNamespace(client.KubeClient, ctx.String("namespace"), ctx.Bool("redact-secrets"))
+							if err != nil {
+								return err
+							}
+							log.Printf("backup namespace %s with %d objects, id = %s, file = %s", ctx.String("namespace"), len(e.Objects), e.ID, backup.Path(e))
+							return nil
+						},
+					},
+					{
+						Name:  "keygen",
+						Usage: "generate the key pair to encrypt secrets in backups, put the public key in backup.recipient of k8sctl config",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "out",
+								Usage:    "private key file",
+								Value:    filepath.Join(homedir.HomeDir(), ".kube", "k8sctl-identity"),
+								Required: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							out := ctx.String("out")
+							if _, err := os.Lstat(out); err == nil {
+								return fmt.Errorf("%s already exist, remove it first or use --out", out)
+							}
+							pub, priv, err := backup.GenerateKey()
+							if err != nil {
+								return err
+							}
+							if err := os.WriteFile(out, []byte(fmt.Sprintf("# public key: %s\n%s\n", pub, priv)), 0600); err != nil {
+								return err
+							}
+							log.Printf("private key written to %s, keep it out of the backup storage", out)
+							fmt.Printf("backup:\n  recipient: %s\n", pub)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "restore objects from a k8sctl backup file or namespace snapshot, a diff against the live objects is printed first",
+				ArgsUsage: "<backup-file|backup-id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "namespace",
+						Aliases:  []string{"ns"},
+						Usage:    "restore into this namespace instead of the one in the backup",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "dry-run",
+						Usage:    "print the diff and run server dry-run only",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "on-conflict",
+						Usage:    "when the object exist, skip|replace|apply, apply stop on fields owned by other managers",
+						Value:    manifest.ConflictSkip,
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "identity",
+						Usage:    "private key file from \"backup keygen\", to decrypt secrets",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "force",
+						Usage:    "go on with a backup taken from another cluster",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name:     "kind",
+						Usage:    "only restore objects of this kind, can be repeated",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name:     "name",
+						Usage:    "only restore objects with this name, can be repeated",
+						Required: false,
+					},
+				},
+				Action: audited("restore", "", func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return fmt.Errorf("usage: k8sctl restore <backup-file|backup-id>")
+					}
+					switch ctx.String("on-conflict") {
+					case manifest.ConflictSkip, manifest.ConflictReplace, manifest.ConflictApply:
+					default:
+						return fmt.Errorf("--on-conflict = %s not match skip|replace|apply", ctx.String("on-conflict"))
+					}
+
+					cluster, objs, err := backup.Load(ctx.Args().First())
+					if err != nil {
+						return err
+					}
+					if err := backup.CheckCluster(cluster, ctx.Bool("force")); err != nil {
+						return err
+					}
+					// only the secrets going to be restored need the identity
+					objs = backup.SkipRedacted(manifest.Filter(objs, ctx.StringSlice("kind"), ctx.StringSlice("name")))
+					if len(objs) == 0 {
+						return fmt.Errorf("no object in %s match --kind %v --name %v", ctx.Args().First(), ctx.StringSlice("kind"), ctx.StringSlice("name"))
+					}
+					if err := backup.DecryptSecrets(objs, ctx.String("identity")); err != nil {
+						return err
+					}
+
+					client, err := k8scrdClient.NewClient()
+					if err != nil {
+						return err
+					}
+					dc, err := dynamic.NewForConfig(client.RestConfig)
+					if err != nil {
+						return err
+					}
+					r := &manifest.Restore{
+						Client:     client.KubeClient,
+						Dynamic:    dc,
+						Namespace:  ctx.String("namespace"),
+						DryRun:     ctx.Bool("dry-run"),
+						OnConflict: ctx.String("on-conflict"),
+						Out:        os.Stdout,
+						BeforeChange: func(live *unstructured.Unstructured) error {
+							_, err := backup.BeforeMutation("restore", live)
+							return err
+						},
+					}
+					return r.Run(objs)
+				}),
+			},
+			{
+				Name:      "diff",
+				Usage:     "diff a backup against the live objects, or two backups, runtime fields like status and resourceVersion are ignored",
+				ArgsUsage: "<backup> [<backup>]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "table|json|yaml|wide|markdown",
+						Value:    printer.Table,
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "identity",
+						Usage:    "private key file from \"backup keygen\", to decrypt secrets",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "force",
+						Usage:    "go on with a backup taken from another cluster",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name:     "kind",
+						Usage:    "only diff objects of this kind, can be repeated",
+						Required: false,
+					},
+					&cli.StringSliceFlag{
+						Name:     "name",
+						Usage:    "only diff objects with this name, can be repeated",
+						Required: false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 && ctx.NArg() != 2 {
+						return fmt.Errorf("usage: k8sctl diff <backup-file|backup-id> [<backup-file|backup-id>]")
+					}
+					p, err := printer.New(ctx.String("output"), os.Stdout)
+					if err != nil {
+						return err
+					}
+
+					cluster, before, err := backup.Load(ctx.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					// a redacted secret has no values to compare
+					before = backup.SkipRedacted(manifest.Filter(before, ctx.StringSlice("kind"), ctx.StringSlice("name")))
+					if len(before) == 0 {
+						return fmt.Errorf("no object in %s match --kind %v --name %v", ctx.Args().Get(0), ctx.StringSlice("kind"), ctx.StringSlice("name"))
+					}
+					if err := backup.DecryptSecrets(before, ctx.String("identity")); err != nil {
+						return err
+					}
+					var after []map[string]any
+					if ctx.NArg() == 2 {
+						if _, after, err = backup.Load(ctx.Args().Get(1)); err == nil {
+							after = backup.SkipRedacted(manifest.Filter(after, ctx.StringSlice("kind"), ctx.StringSlice("name")))
+							err = backup.DecryptSecrets(after, ctx.String("identity"))
+						}
+					} else {
+						if cerr := backup.CheckCluster(cluster, ctx.Bool("force")); cerr != nil {
+							return cerr
+						}
+						client, cerr := k8scrdClient.NewClient()
+						if cerr != nil {
+							return cerr
+						}
+						dc, cerr := dynamic.NewForConfig(client.RestConfig)
+						if cerr != nil {
+							return cerr
+						}
+						after, err = manifest.FetchLive(client.KubeClient, dc, before)
+					}
+					if err != nil {
+						return err
+					}
+					return printDiffs(p, manifest.Compare(before, after))
+				},
+			},
+			{
+				Name:  "audit",
+				Usage: "query the audit log of k8sctl operations",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "object",
+						Usage:    "name, namespace/name, kind/name or kind/namespace/name",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "since",
+						Usage:    "records newer than a duration like 168h, or a date like 2006-01-02",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "user",
+						Usage:    "user who run the operation",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "export",
+						Usage:    "write the records as jsonl to this file instead of printing them",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "sink",
+						Usage:    "post the records as jsonl to this http url",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "table|json|yaml|wide|markdown",
+						Value:    printer.Table,
+						Required: false,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					p, err := printer.New(ctx.String("output"), os.Stdout)
+					if err != nil {
+						return err
+					}
+					since, err := backup.ParseSince(ctx.String("since"))
+					if err != nil {
+						return err
+					}
+					records, err := audit.Search(audit.Query{
+						Object: ctx.String("object"),
+						User:   ctx.String("user"),
+						Since:  since,
+					})
+					if err != nil {
+						return err
+					}
+
+					if ctx.String("sink") != "" {
+						if err := audit.Post(ctx.String("sink"), records); err != nil {
+							return err
+						}
+						log.Printf("Posted %d audit records to %s", len(records), ctx.String("sink"))
+					}
+					if ctx.String("export") != "" {
+						f, err := os.Create(ctx.String("export"))
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						if err := audit.Export(f, records); err != nil {
+							return err
+						}
+						log.Printf("Exported %d audit records to %s", len(records), ctx.String("export"))
+					}
+					if ctx.String("sink") == "" && ctx.String("export") == "" {
+						return printAudit(p, records)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "gc",
+				Usage: "garbage collect k8s resources",
+				Subcommands: []*cli.Command{
+					{
+						Name:    "previews",
+						Aliases: []string{"preview"},
+						Usage:   "delete expired copies created by `copy --ttl`",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"ns"},
+								Usage:    "namespace, if not set or ns=all will check all namespace",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "delete-namespace",
+								Usage:    "also delete namespace created by k8sctl when all copies in it expired",
+								Required: false,
+							},
+							&cli.BoolFlag{
+								Name:     "dry-run",
+								Usage:    "only print expired copies",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "table|json|yaml|wide|markdown",
+								Value:    printer.Table,
+								Required: false,
+							},
+						},
+						Action: audited("gc previews", "", func(ctx *cli.Context) error {
+							p, err := printer.New(ctx.String("output"), os.Stdout)
+							if err != nil {
+								return err
+							}
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+
+							g := &preview.GC{
+								Client:          client.KubeClient,
+								Namespace:       ctx.String("namespace"),
+								DeleteNamespace: ctx.Bool("delete-namespace"),
+								DryRun:          ctx.Bool("dry-run"),
+							}
+							deleted, err := g.Run()
+							if err != nil {
+								return err
+							}
+
+							if len(deleted) == 0 && p.Human() {
+								slog.Info("not found any expired copy")
+								return nil
+							}
+							if g.DryRun {
+								log.Printf("Dry run, %d expired copies are not deleted", len(deleted))
+							}
+							return printCopies(p, deleted)
+						}),
+					},
+				},
+			},
+			{
+				Name:    "delete",
+				Usage:   "delete k8s resources",
+				Aliases: []string{"del"},
+				Subcommands: []*cli.Command{
+					{
+						Name:    "deployment",
+						Aliases: []string{"deploy"},
+						Usage:   "del every object created by k8sctl copy for the deployment or app, objects not created by k8sctl are never touched",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "deployment name of the copy, or app name of copy app",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "namespace",
+								Aliases:  []string{"ns"},
+								Usage:    "namespace",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:     "dry-run",
+								Usage:    "only print the objects to delete",
+								Required: false,
+							},
+							&cli.IntFlag{
+								Name:     "timeout",
+								Aliases:  []string{"time"},
+								Usage:    "seconds to wait for pods terminated",
+								Value:    180,
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "table|json|yaml|wide|markdown",
+								Value:    printer.Table,
+								Required: false,
+							},
+						},
+						Action: audited("delete deployment", "Deployment", func(ctx *cli.Context) error {
+							p, err := printer.New(ctx.String("output"), os.Stdout)
+							if err != nil {
+								return err
+							}
+							client, err := k8scrdClient.NewClient()
+							if err != nil {
+								return err
+							}
+							c := &preview.Cleanup{
+								Client:    client.KubeClient,
+								Namespace: ctx.String("namespace"),
+								Copy:      ctx.String("name"),
+								DryRun:    ctx.Bool("dry-run"),
+								Timeout:   ctx.Int("timeout"),
+							}
+							deleted, err := c.Run()
+							if c.DryRun {
+								log.Printf("Dry run, %d objects are not deleted", len(deleted))
+							}
+							// print what is deleted before a failure stop the rest
+							if perr := printCopies(p, deleted); perr != nil && err == nil {
+								err = perr
+							}
+							return err
+						}),
+					},
+				},
+			},
+		},
+	}
+	app.EnableBashCompletion = true
+	err := app.Run(os.Args)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// ensureNamespace check the --to namespace of copy commands, create it with --create-namespace
+func ensureNamespace(ctx *cli.Context, cs *kubernetes.Clientset) error {
+	ns := &namespace.NamespaceSpec{
+		Client: cs,
+		Name:   ctx.String("to"),
+		Source: ctx.String("from"),
+		Owner:  ctx.String("owner"),
+		TTL:    ctx.Duration("ttl"),
+	}
+	return ns.Ensure(ctx.Bool("create-namespace"))
+}
+
+// healthFlags return the flags of get deployment|statefulset|daemonset|job|all
+func healthFlags(controllers string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "namespace",
+			Aliases:  []string{"ns"},
+			Usage:    controllers + ` namespace,  if not set or ns=all will use check all namespace`,
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "selector",
+			Aliases:  []string{"l"},
+			Usage:    "label selector of " + controllers,
+			Required: false,
+		},
+		&cli.StringSliceFlag{
+			Name:     "include-namespace",
+			Usage:    "only check namespaces match the glob pattern, e.g. team-*, can be repeated",
+			Required: false,
+		},
+		&cli.StringSliceFlag{
+			Name:     "exclude-namespace",
+			Usage:    "skip namespaces match the glob pattern, e.g. kube-*, can be repeated",
+			Required: false,
+		},
+		&cli.IntFlag{
+			Name:     "restart-threshold",
+			Usage:    "a not ready container restarted more than it is unhealthy",
+			Value:    health.DefaultRestartThreshold,
+			Required: false,
+		},
+		&cli.DurationFlag{
+			Name:     "restarts-within",
+			Usage:    "only count restarts within it, e.g. 24h, 0 means any time, the restart count is of the container life, a container over --restart-threshold is unhealthy when its last restart is within it",
+			Required: false,
+		},
+		&cli.DurationFlag{
+			Name:     "jobs-within",
+			Usage:    "only count jobs failed within it, 0 means any time",
+			Value:    health.DefaultJobsWithin,
+			Required: false,
+		},
+		&cli.DurationFlag{
+			Name:     "min-age",
+			Usage:    "skip pods younger than it, e.g. 5m",
+			Required: false,
+		},
+		&cli.IntFlag{
+			Name:     "events",
+			Usage:    "latest events attached to every pod and its controllers, 0 to not fetch events",
+			Value:    health.DefaultEventLimit,
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "table|json|yaml|wide|markdown",
+			Value:    printer.Table,
+			Required: false,
+		},
+	}
+}
+
+// healthAction check the controllers of kind and print what is unhealthy
+func healthAction(kind string) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		p, err := printer.New(ctx.String("output"), os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		ns := ctx.String("namespace")
+		cs, err := k8scrdClient.NewClient()
+		if err != nil {
+			return err
+		}
+
+		if ns == "" || ns == "all" {
+			ns = "all"
+		}
+		c := &health.Check{
+			Client:            cs.KubeClient,
+			Namespace:         ns,
+			Selector:          ctx.String("selector"),
+			IncludeNamespaces: ctx.StringSlice("include-namespace"),
+			ExcludeNamespaces: ctx.StringSlice("exclude-namespace"),
+			RestartThreshold:  int32(ctx.Int("restart-threshold")),
+			RestartsWithin:    ctx.Duration("restarts-within"),
+			JobsWithin:        ctx.Duration("jobs-within"),
+			MinAge:            ctx.Duration("min-age"),
+			EventLimit:        ctx.Int("events"),
+		}
+		found, err := c.Run(kind)
+		if err != nil {
+			return err
+		}
+
+		if len(found) == 0 && p.Human() {
+			slog.Info("not found anything unhealthy of your cluster", "kind", kind, "ns", ns)
+			return nil
+		}
+		return printUnhealthy(p, found)
+	}
+}
+
+func printUnhealthy(p *printer.Printer, found []health.Unhealthy) error {
+	columns := []printer.Column{
+		{Name: "NAMESPACE"}, {Name: "KIND"}, {Name: "CONTROLLER"}, {Name: "POD"}, {Name: "REASON"}, {Name: "RESTARTS"}, {Name: "LAST EVENT"},
+		{Name: "NODE", Wide: true}, {Name: "CONTAINER", Wide: true}, {Name: "MESSAGE", Wide: true}, {Name: "EVENTS", Wide: true},
+	}
+	var rows [][]string
+	for _, u := range found {
+		pod := u.Pod
+		if pod == "" {
+			pod = "-"
+		}
+		// events are newest first
+		lastEvent := "-"
+		var events []string
+		for _, e := range u.Events {
+			events = append(events, e.String())
+		}
+		if len(events) > 0 {
+			lastEvent = events[0]
+		}
+		rows = append(rows, []string{u.Namespace, u.Kind, u.Controller, pod, u.Reason, fmt.Sprint(u.Restarts), lastEvent,
+			u.Node, u.Container, u.Message, strings.Join(events, "; ")})
+	}
+	return p.Print("UnhealthyList", found, columns, rows)
+}
+
+func printBackups(p *printer.Printer, entries []backup.Entry) error {
+	if len(entries) == 0 && p.Human() {
+		slog.Info("not found any backup")
+		return nil
+	}
+	columns := []printer.Column{
+		{Name: "ID"}, {Name: "TIME"}, {Name: "OPERATION"}, {Name: "USER"}, {Name: "CONTEXT"}, {Name: "OBJECTS"},
+		{Name: "CLUSTER", Wide: true}, {Name: "FILE", Wide: true}, {Name: "CHECKSUM", Wide: true},
+	}
+	var rows [][]string
+	for _, e := range entries {
+		var objs []string
+		for _, o := range e.Objects {
+			objs = append(objs, o.Kind+"/"+o.Name)
+		}
+		rows = append(rows, []string{e.ID, e.Time.Format("2006-01-02 15:04:05"), e.Operation, e.User, e.Context, strings.Join(objs, ","),
+			e.Cluster, e.File, e.Checksum})
+	}
+	return p.Print("BackupList", entries, columns, rows)
+}
+
+func printDiffs(p *printer.Printer, diffs []manifest.ObjectDiff) error {
+	columns := []printer.Column{{Name: "KIND"}, {Name: "NAMESPACE"}, {Name: "NAME"}, {Name: "STATUS"}, {Name: "CHANGE"}}
+	var rows [][]string
+	for _, d := range diffs {
+		if len(d.Changes) == 0 {
+			rows = append(rows, []string{d.Kind, d.Namespace, d.Name, d.Status, ""})
+		}
+		for _, c := range d.Changes {
+			rows = append(rows, []string{d.Kind, d.Namespace, d.Name, d.Status, c.String()})
+		}
+	}
+	return p.Print("ObjectDiffList", diffs, columns, rows)
+}
+
+// printCopies print the objects deleted by gc and delete, or to delete by --dry-run
+func printCopies(p *printer.Printer, objs []preview.Object) error {
+	columns := []printer.Column{
+		{Name: "KIND"}, {Name: "NAMESPACE"}, {Name: "NAME"}, {Name: "OWNER"}, {Name: "EXPIRES-AT"},
+		{Name: "SOURCE", Wide: true}, {Name: "COPY", Wide: true},
+	}
+	var rows [][]string
+	for _, obj := range objs {
+		rows = append(rows, []string{obj.Kind, obj.Namespace, obj.Name, obj.Annotations[preview.AnnotationOwner],
+			obj.Annotations[preview.AnnotationExpiresAt], obj.Annotations[preview.AnnotationSource], obj.Annotations[preview.AnnotationCopy]})
+	}
+	return p.Print("CopyList", objs, columns, rows)
+}
+
+func printAudit(p *printer.Printer, records []audit.Record) error {
+	if len(records) == 0 && p.Human() {
+		slog.Info("not found any audit record")
+		return nil
+	}
+	columns := []printer.Column{
+		{Name: "TIME"}, {Name: "OPERATION"}, {Name: "USER"}, {Name: "OBJECTS"}, {Name: "RESULT"}, {Name: "DURATION"},
+		{Name: "CONTEXT", Wide: true}, {Name: "BEFORE", Wide: true}, {Name: "AFTER", Wide: true}, {Name: "ARGS", Wide: true},
+	}
+	var rows [][]string
+	for _, r := range records {
+		var objects []string
+		for _, o := range r.Objects {
+			objects = append(objects, fmt.Sprintf("%s/%s/%s", o.Kind, o.Namespace, o.Name))
+		}
+		result := r.Result
+		if r.Error != "" {
+			result += ": " + r.Error
+		}
+		rows = append(rows, []string{r.Time.Format("2006-01-02 15:04:05"), r.Operation, r.User, strings.Join(objects, ","), result,
+			(time.Duration(r.DurationMs) * time.Millisecond).String(), r.Context,
+			strings.Join(r.Before, ","), strings.Join(r.After, ","), strings.Join(r.Args, " ")})
+	}
+	return p.Print("AuditRecordList", records, columns, rows)
+}
+
+// audited record the command in audit.jsonl, the object is taken from --name and --namespace, or --to for copy commands
+func audited(operation, kind string, action cli.ActionFunc) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		var objects []backup.ObjectRef
+		if kind != "" && ctx.String("name") != "" {
+			// copy commands change the object in --to, not the source in --from
+			ns := ctx.String("to")
+			if ns == "" {
+				ns = ctx.String("namespace")
+			}
+			name := ctx.String("name")
+			if ctx.String("new-name") != "" {
+				name = ctx.String("new-name")
+			}
+			objects = append(objects, backup.ObjectRef{Kind: kind, Namespace: ns, Name: name})
+		}
+		op := audit.Start(operation, os.Args[1:], objects...)
+		return op.Finish(action(ctx))
+	}
+}
@@ -0,0 +1,125 @@
+package manifest
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	DiffChanged   = "changed"
+	DiffUnchanged = "unchanged"
+	// only in the new side
+	DiffAdded = "added"
+	// only in the old side
+	DiffRemoved = "removed"
+)
+
+// ObjectDiff is the field level diff of one object
+type ObjectDiff struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Changes   []Change `json:"changes,omitempty"`
+}
+
+// Compare match objects by kind/namespace/name and diff each pair,
+// both sides are sanitized so runtime fields like status and resourceVersion are ignored
+func Compare(old, new []map[string]any) []ObjectDiff {
+	newByKey := make(map[string]map[string]any)
+	for _, obj := range new {
+		newByKey[objectKey(obj)] = obj
+	}
+
+	var diffs []ObjectDiff
+	seen := make(map[string]bool)
+	for _, o := range old {
+		key := objectKey(o)
+		seen[key] = true
+		d := objectDiff(o)
+		n, ok := newByKey[key]
+		if !ok {
+			d.Status = DiffRemoved
+			diffs = append(diffs, d)
+			continue
+		}
+		o, n = clean(o), clean(n)
+		d.Changes = MaskSecret(d.Kind, Diff(o, n))
+		d.Status = DiffUnchanged
+		if len(d.Changes) > 0 {
+			d.Status = DiffChanged
+		}
+		diffs = append(diffs, d)
+	}
+	for _, n := range new {
+		if seen[objectKey(n)] {
+			continue
+		}
+		d := objectDiff(n)
+		d.Status = DiffAdded
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+func clean(obj map[string]any) map[string]any {
+	c := (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+	Sanitize(c)
+	return c
+}
+
+func objectDiff(obj map[string]any) ObjectDiff {
+	u := &unstructured.Unstructured{Object: obj}
+	return ObjectDiff{Kind: u.GetKind(), Namespace: u.GetNamespace(), Name: u.GetName()}
+}
+
+func objectKey(obj map[string]any) string {
+	u := &unstructured.Unstructured{Object: obj}
+	return u.GetKind() + "/" + u.GetNamespace() + "/" + u.GetName()
+}
+
+// FetchLive get the live object of each of objs, objects not found in the cluster are left out
+func FetchLive(cs *kubernetes.Clientset, dc dynamic.Interface, objs []map[string]any) ([]map[string]any, error) {
+	groups, err := restmapper.GetAPIGroupResources(cs.Discovery())
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groups)
+
+	var live []map[string]any
+	for _, obj := range objs {
+		u := &unstructured.Unstructured{Object: obj}
+		ri, err := resourceFor(mapper, dc, u)
+		if err != nil {
+			return nil, err
+		}
+		l, err := ri.Get(context.TODO(), u.GetName(), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		live = append(live, l.Object)
+	}
+	return live, nil
+}
+
+func resourceFor(mapper meta.RESTMapper, dc dynamic.Interface, u *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := u.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return dc.Resource(mapping.Resource).Namespace(u.GetNamespace()), nil
+	}
+	return dc.Resource(mapping.Resource), nil
+}
@@ -0,0 +1,165 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Change is one changed field, Old is nil for an added field and New is nil for a removed one
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Diff compare two objects field by field, paths look like spec.template.spec.containers[0].image
+func Diff(old, new map[string]any) []Change {
+	var changes []Change
+	diffValue("", old, new, &changes)
+	return changes
+}
+
+func diffValue(path string, old, new any, changes *[]Change) {
+	switch o := old.(type) {
+	case map[string]any:
+		if n, ok := new.(map[string]any); ok {
+			keys := make(map[string]bool)
+			for k := range o {
+				keys[k] = true
+			}
+			for k := range n {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				diffValue(joinPath(path, k), o[k], n[k], changes)
+			}
+			return
+		}
+	case []any:
+		if n, ok := new.([]any); ok {
+			for i := 0; i < len(o) || i < len(n); i++ {
+				var ov, nv any
+				if i < len(o) {
+					ov = o[i]
+				}
+				if i < len(n) {
+					nv = n[i]
+				}
+				diffValue(fmt.Sprintf("%s[%d]", path, i), ov, nv, changes)
+			}
+			return
+		}
+	}
+
+	if old == nil && new == nil {
+		return
+	}
+	if old != nil && new != nil && equalValue(old, new) {
+		return
+	}
+	*changes = append(*changes, Change{Path: path, Old: old, New: new})
+}
+
+// hiddenValue replace secret values in printed changes
+const hiddenValue = "(hidden)"
+
+// MaskSecret hide the values of changes in data, stringData and the last-applied annotation when kind is Secret,
+// the paths are kept so a changed key is still seen
+func MaskSecret(kind string, changes []Change) []Change {
+	if kind != "Secret" {
+		return changes
+	}
+	masked := make([]Change, len(changes))
+	for i, c := range changes {
+		if secretPath(c.Path) {
+			if c.Old != nil {
+				c.Old = hiddenValue
+			}
+			if c.New != nil {
+				c.New = hiddenValue
+			}
+		}
+		masked[i] = c
+	}
+	return masked
+}
+
+func secretPath(path string) bool {
+	for _, prefix := range []string{"data", "stringData", "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]", "metadata.annotations"} {
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok {
+			continue
+		}
+		if prefix == "metadata.annotations" {
+			// the whole annotations map is added or removed
+			return rest == ""
+		}
+		if rest == "" || rest[0] == '.' || rest[0] == '[' {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValue(a, b any) bool {
+	switch a.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	switch b.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return equal(a, b)
+}
+
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, "./") {
+		key = "[" + key + "]"
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// PrintChanges write changes as text, one line per field
+func PrintChanges(w io.Writer, changes []Change) {
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+}
+
+// String is the change on one line, + for an added field, - for a removed one and ~ for a changed one
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("+ %s: %s", c.Path, inline(c.New))
+	case c.New == nil:
+		return fmt.Sprintf("- %s: %s", c.Path, inline(c.Old))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, inline(c.Old), inline(c.New))
+}
+
+// inline render a value on one line
+func inline(v any) string {
+	switch v.(type) {
+	case map[string]any, []any:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return strings.ReplaceAll(strings.TrimSpace(string(data)), "\n", "; ")
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		want     []Change
+	}{
+		{
+			name: "unchanged with different number types",
+			old:  `{spec: {replicas: 2}}`,
+			new:  `{spec: {replicas: 2}}`,
+		},
+		{
+			name: "changed, added and removed fields",
+			old:  `{spec: {replicas: 2, paused: true}}`,
+			new:  `{spec: {replicas: 3, minReadySeconds: 5}}`,
+			want: []Change{
+				{Path: "spec.minReadySeconds", New: float64(5)},
+				{Path: "spec.paused", Old: true},
+				{Path: "spec.replicas", Old: float64(2), New: float64(3)},
+			},
+		},
+		{
+			name: "list items by index",
+			old:  `{spec: {containers: [{name: app, image: "nginx:1"}]}}`,
+			new:  `{spec: {containers: [{name: app, image: "nginx:2"}, {name: side}]}}`,
+			want: []Change{
+				{Path: "spec.containers[0].image", Old: "nginx:1", New: "nginx:2"},
+				{Path: "spec.containers[1]", New: map[string]any{"name": "side"}},
+			},
+		},
+		{
+			name: "keys with dots and slashes",
+			old:  `{metadata: {labels: {app.kubernetes.io/name: a}}}`,
+			new:  `{metadata: {labels: {app.kubernetes.io/name: b}}}`,
+			want: []Change{
+				{Path: "metadata.labels[app.kubernetes.io/name]", Old: "a", New: "b"},
+			},
+		},
+		{
+			name: "map replaced by scalar",
+			old:  `{data: {a: b}}`,
+			new:  `{data: x}`,
+			want: []Change{
+				{Path: "data", Old: map[string]any{"a": "b"}, New: "x"},
+			},
+		},
+	}
+	for _, c := range cases {
+		got := Diff(parseYAML(t, c.old), parseYAML(t, c.new))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPrintChanges(t *testing.T) {
+	var buf bytes.Buffer
+	PrintChanges(&buf, []Change{
+		{Path: "spec.a", New: 1},
+		{Path: "spec.b", Old: "x"},
+		{Path: "spec.c", Old: 1, New: 2},
+	})
+	want := "+ spec.a: 1\n- spec.b: x\n~ spec.c: 1 -> 2\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	changes := []Change{
+		{Path: "data.password", Old: "b2xk", New: "bmV3"},
+		{Path: "data[tls.crt]", New: "Y2VydA=="},
+		{Path: "stringData", Old: map[string]any{"user": "admin"}},
+		{Path: "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]", Old: `{"data":{"password":"b2xk"}}`},
+		{Path: "metadata.annotations", New: map[string]any{"kubectl.kubernetes.io/last-applied-configuration": "{}"}},
+		{Path: "metadata.annotations[k8sctl.io/owner]", Old: "ci", New: "alice"},
+		{Path: "metadata.labels.dataset", Old: "a", New: "b"},
+		{Path: "type", Old: "Opaque", New: "kubernetes.io/tls"},
+	}
+
+	tests := []struct {
+		kind string
+		want []string
+	}{
+		{
+			kind: "Secret",
+			want: []string{
+				"~ data.password: (hidden) -> (hidden)",
+				"+ data[tls.crt]: (hidden)",
+				"- stringData: (hidden)",
+				"- metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]: (hidden)",
+				"+ metadata.annotations: (hidden)",
+				"~ metadata.annotations[k8sctl.io/owner]: ci -> alice",
+				"~ metadata.labels.dataset: a -> b",
+				"~ type: Opaque -> kubernetes.io/tls",
+			},
+		},
+		{
+			kind: "ConfigMap",
+			want: []string{
+				"~ data.password: b2xk -> bmV3",
+				"+ data[tls.crt]: Y2VydA==",
+				"- stringData: user: admin",
+				`- metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]: {"data":{"password":"b2xk"}}`,
+				"+ metadata.annotations: kubectl.kubernetes.io/last-applied-configuration: '{}'",
+				"~ metadata.annotations[k8sctl.io/owner]: ci -> alice",
+				"~ metadata.labels.dataset: a -> b",
+				"~ type: Opaque -> kubernetes.io/tls",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			got := MaskSecret(tt.kind, changes)
+			for i, c := range got {
+				if c.String() != tt.want[i] {
+					t.Errorf("change %d = %q, want %q", i, c.String(), tt.want[i])
+				}
+			}
+		})
+	}
+	if changes[0].Old != "b2xk" {
+		t.Error("MaskSecret changed its input")
+	}
+}
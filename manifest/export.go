@@ -0,0 +1,188 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+type Export struct {
+	// write files into Dir, print to Out if empty
+	Dir string
+	Out io.Writer
+	// one file per kind, only with Dir
+	Split bool
+	// emit kustomization.yaml, only with Dir
+	Kustomize bool
+	// replace image tags with ${ImageTagVar}, e.g. IMAGE_TAG
+	ImageTagVar string
+}
+
+// Write sanitise objs and write them out
+func (e *Export) Write(objs []map[string]any) error {
+	if e.Dir == "" && (e.Split || e.Kustomize) {
+		return fmt.Errorf("--split and --kustomize need --dir")
+	}
+
+	// image name -> tag, for kustomization images
+	images := make(map[string]string)
+	for _, obj := range objs {
+		Sanitize(obj)
+		// ownership of copies is cluster state, not part of the app
+		if meta, ok := obj["metadata"].(map[string]any); ok {
+			if annotations, ok := meta["annotations"].(map[string]any); ok {
+				for k := range annotations {
+					if strings.HasPrefix(k, "k8sctl.io/") {
+						delete(annotations, k)
+					}
+				}
+				removeIfEmpty(meta, "annotations")
+			}
+		}
+		for _, c := range containers(obj) {
+			image, _ := c["image"].(string)
+			name, tag := splitImage(image)
+			if tag == "" {
+				continue
+			}
+			if e.ImageTagVar != "" {
+				tag = "${" + e.ImageTagVar + "}"
+				c["image"] = name + ":" + tag
+			}
+			images[name] = tag
+		}
+	}
+
+	if e.Dir == "" {
+		data, err := marshalAll(objs)
+		if err != nil {
+			return err
+		}
+		_, err = e.Out.Write(data)
+		return err
+	}
+
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return err
+	}
+
+	// file name -> objects, in the order of objs
+	var files []string
+	byFile := make(map[string][]map[string]any)
+	for _, obj := range objs {
+		file := "resources.yaml"
+		if e.Split {
+			file = strings.ToLower(fmt.Sprint(obj["kind"])) + ".yaml"
+		}
+		if _, ok := byFile[file]; !ok {
+			files = append(files, file)
+		}
+		byFile[file] = append(byFile[file], obj)
+	}
+
+	for _, file := range files {
+		data, err := marshalAll(byFile[file])
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(e.Dir, file)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		log.Printf("Export %d objects to %s", len(byFile[file]), path)
+	}
+
+	if e.Kustomize {
+		return e.writeKustomization(files, images)
+	}
+	return nil
+}
+
+func (e *Export) writeKustomization(files []string, images map[string]string) error {
+	k := map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  files,
+	}
+	if len(images) > 0 {
+		names := make([]string, 0, len(images))
+		for name := range images {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var list []map[string]string
+		for _, name := range names {
+			list = append(list, map[string]string{"name": name, "newTag": images[name]})
+		}
+		k["images"] = list
+	}
+
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(e.Dir, "kustomization.yaml")
+	log.Printf("Export kustomization to %s", path)
+	return os.WriteFile(path, data, 0644)
+}
+
+// containers return the containers of a workload
+func containers(obj map[string]any) []map[string]any {
+	spec, _ := obj["spec"].(map[string]any)
+	if spec == nil {
+		return nil
+	}
+	if obj["kind"] == "CronJob" {
+		jt, _ := spec["jobTemplate"].(map[string]any)
+		spec, _ = jt["spec"].(map[string]any)
+	}
+	tpl, _ := spec["template"].(map[string]any)
+	podSpec, _ := tpl["spec"].(map[string]any)
+
+	var cs []map[string]any
+	for _, key := range []string{"initContainers", "containers"} {
+		list, _ := podSpec[key].([]any)
+		for _, c := range list {
+			if container, ok := c.(map[string]any); ok {
+				cs = append(cs, container)
+			}
+		}
+	}
+	return cs
+}
+
+// splitImage split image to name and tag, registry port is not a tag
+func splitImage(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+// marshalAll write objs as multi-document yaml
+func marshalAll(objs []map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package manifest
+
+import (
+	"context"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// toMap convert a typed object from clientset to unstructured, objects from clientset have no apiVersion and kind
+func toMap(obj runtime.Object, gvk schema.GroupVersionKind) (map[string]any, error) {
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// FetchDeployment get the deployment and the service with the same name
+func FetchDeployment(cs *kubernetes.Clientset, ns, name string) ([]map[string]any, error) {
+	var objs []map[string]any
+
+	deploy, err := cs.AppsV1().Deployments(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Get deployment = %s.%s err: %v", ns, name, err)
+		return nil, err
+	}
+	m, err := toMap(deploy, schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, m)
+
+	svc, err := cs.CoreV1().Services(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("INFO: Service = %s, namespace = %s not found.\n", name, ns)
+		return objs, nil
+	}
+	m, err = toMap(svc, schema.GroupVersionKind{Version: "v1", Kind: "Service"})
+	if err != nil {
+		return nil, err
+	}
+	return append(objs, m), nil
+}
+
+// FetchApp get every supported object in ns with the label selector
+func FetchApp(cs *kubernetes.Clientset, ns, selector string) ([]map[string]any, error) {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	var objs []map[string]any
+	add := func(obj runtime.Object, gvk schema.GroupVersionKind) error {
+		m, err := toMap(obj, gvk)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, m)
+		return nil
+	}
+
+	cms, err := cs.CoreV1().ConfigMaps(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cms.Items {
+		if err := add(&cms.Items[i], schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}); err != nil {
+			return nil, err
+		}
+	}
+
+	svcs, err := cs.CoreV1().Services(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range svcs.Items {
+		if err := add(&svcs.Items[i], schema.GroupVersionKind{Version: "v1", Kind: "Service"}); err != nil {
+			return nil, err
+		}
+	}
+
+	deploys, err := cs.AppsV1().Deployments(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deploys.Items {
+		if err := add(&deploys.Items[i], schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}); err != nil {
+			return nil, err
+		}
+	}
+
+	stss, err := cs.AppsV1().StatefulSets(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stss.Items {
+		if err := add(&stss.Items[i], schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}); err != nil {
+			return nil, err
+		}
+	}
+
+	cronjobs, err := cs.BatchV1().CronJobs(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cronjobs.Items {
+		if err := add(&cronjobs.Items[i], schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}); err != nil {
+			return nil, err
+		}
+	}
+
+	ingresses, err := cs.NetworkingV1().Ingresses(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ingresses.Items {
+		if err := add(&ingresses.Items[i], schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}); err != nil {
+			return nil, err
+		}
+	}
+
+	hpas, err := cs.AutoscalingV2().HorizontalPodAutoscalers(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hpas.Items {
+		if err := add(&hpas.Items[i], schema.GroupVersionKind{Group: "autoscaling", Version: "v2", Kind: "HorizontalPodAutoscaler"}); err != nil {
+			return nil, err
+		}
+	}
+
+	pdbs, err := cs.PolicyV1().PodDisruptionBudgets(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pdbs.Items {
+		if err := add(&pdbs.Items[i], schema.GroupVersionKind{Group: "policy", Version: "v1", Kind: "PodDisruptionBudget"}); err != nil {
+			return nil, err
+		}
+	}
+
+	return objs, nil
+}
+
+// FetchSecrets get the secrets in ns, service account tokens are managed by k8s and left out.
+// the values are in plaintext, also in the last-applied annotation, protect them before writing anywhere
+func FetchSecrets(cs *kubernetes.Clientset, ns string) ([]map[string]any, error) {
+	secrets, err := cs.CoreV1().Secrets(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []map[string]any
+	for i := range secrets.Items {
+		if secrets.Items[i].Type == corev1.SecretTypeServiceAccountToken {
+			continue
+		}
+		secrets.Items[i].ManagedFields = nil
+		m, err := toMap(&secrets.Items[i], schema.GroupVersionKind{Version: "v1", Kind: "Secret"})
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, m)
+	}
+	return objs, nil
+}
@@ -0,0 +1,65 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Parse read a multi-document yaml, like the backup files of k8sctl
+func Parse(data []byte) ([]map[string]any, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+
+	var objs []map[string]any
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var obj map[string]any
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// Filter keep the objects match any of kinds and any of names, empty means all
+func Filter(objs []map[string]any, kinds, names []string) []map[string]any {
+	match := func(list []string, v string) bool {
+		if len(list) == 0 {
+			return true
+		}
+		for _, s := range list {
+			if strings.EqualFold(s, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var found []map[string]any
+	for _, obj := range objs {
+		name := ""
+		if meta, ok := obj["metadata"].(map[string]any); ok {
+			name, _ = meta["name"].(string)
+		}
+		if match(kinds, fmt.Sprint(obj["kind"])) && match(names, name) {
+			found = append(found, obj)
+		}
+	}
+	return found
+}
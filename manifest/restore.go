@@ -0,0 +1,150 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// keep the live object
+	ConflictSkip = "skip"
+	// update the live object with the backup
+	ConflictReplace = "replace"
+	// server-side apply the backup without force, a field another manager owns with a different value
+	// is a conflict and the object is left as it is
+	ConflictApply = "apply"
+
+	FieldManager = "k8sctl"
+)
+
+type Restore struct {
+	Client  *kubernetes.Clientset
+	Dynamic dynamic.Interface
+	// restore into this namespace instead of the one in the backup
+	Namespace  string
+	DryRun     bool
+	OnConflict string
+	// diff is written to Out
+	Out io.Writer
+	// called with the live object right before it is replaced or applied, e.g. to back it up
+	BeforeChange func(live *unstructured.Unstructured) error
+}
+
+// Run create or replace objs, a diff against the live object is printed first
+func (r *Restore) Run(objs []map[string]any) error {
+	groups, err := restmapper.GetAPIGroupResources(r.Client.Discovery())
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groups)
+
+	for _, obj := range objs {
+		Sanitize(obj)
+		u := &unstructured.Unstructured{Object: obj}
+		if r.Namespace != "" {
+			u.SetNamespace(r.Namespace)
+		}
+		if err := r.restore(mapper, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Restore) restore(mapper meta.RESTMapper, u *unstructured.Unstructured) error {
+	ri, err := resourceFor(mapper, r.Dynamic, u)
+	if err != nil {
+		return err
+	}
+	var dryRun []string
+	if r.DryRun {
+		dryRun = []string{metav1.DryRunAll}
+	}
+	id := fmt.Sprintf("%s %s/%s", u.GetKind(), u.GetNamespace(), u.GetName())
+
+	live, err := ri.Get(context.TODO(), u.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		fmt.Fprintf(r.Out, "\n===== %s not found, create it\n", id)
+		if _, err := ri.Create(context.TODO(), u, metav1.CreateOptions{DryRun: dryRun}); err != nil {
+			log.Printf("Restore create %s err: %v", id, err)
+			return err
+		}
+		log.Printf("Restore create %s complete, dry-run = %t", id, r.DryRun)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	liveObj := live.DeepCopy().Object
+	Sanitize(liveObj)
+	changes := MaskSecret(u.GetKind(), Diff(liveObj, u.Object))
+	if len(changes) == 0 {
+		fmt.Fprintf(r.Out, "\n===== %s unchanged\n", id)
+		return nil
+	}
+	fmt.Fprintf(r.Out, "\n===== %s diff (live -> backup)\n", id)
+	PrintChanges(r.Out, changes)
+
+	if (r.OnConflict == ConflictReplace || r.OnConflict == ConflictApply) && !r.DryRun && r.BeforeChange != nil {
+		if err := r.BeforeChange(live); err != nil {
+			return err
+		}
+	}
+
+	switch r.OnConflict {
+	case ConflictReplace:
+		u.SetResourceVersion(live.GetResourceVersion())
+		if _, err := ri.Update(context.TODO(), u, metav1.UpdateOptions{DryRun: dryRun}); err != nil {
+			log.Printf("Restore replace %s err: %v", id, err)
+			return err
+		}
+		log.Printf("Restore replace %s complete, dry-run = %t", id, r.DryRun)
+	case ConflictApply:
+		data, err := json.Marshal(u.Object)
+		if err != nil {
+			return err
+		}
+		if _, err := ri.Patch(context.TODO(), u.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			DryRun:       dryRun,
+			FieldManager: FieldManager,
+		}); err != nil {
+			log.Printf("Restore apply %s err: %v", id, err)
+			return applyConflict(id, err)
+		}
+		log.Printf("Restore apply %s complete, dry-run = %t", id, r.DryRun)
+	default:
+		log.Printf("%s already exist, skip it, use --on-conflict replace|apply to overwrite", id)
+	}
+	return nil
+}
+
+// applyConflict list the conflicting fields and their managers of a failed apply
+func applyConflict(id string, err error) error {
+	if !errors.IsConflict(err) {
+		return err
+	}
+	var fields []string
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Details != nil {
+		for _, c := range status.Status().Details.Causes {
+			fields = append(fields, c.Message)
+		}
+	}
+	log.Printf("Restore apply %s conflict with other managers:", id)
+	for _, f := range fields {
+		log.Printf("  %s", f)
+	}
+	return fmt.Errorf("restore apply %s conflict on %d fields owned by other managers, use --on-conflict replace to overwrite them", id, len(fields))
+}
@@ -0,0 +1,264 @@
+package manifest
+
+import (
+	"strings"
+)
+
+// annotations written by the server or by tools, not by the user
+var runtimeAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+// Sanitize strip the fields populated by the server and the values defaulted by it,
+// so the object can be committed to git or created again
+func Sanitize(obj map[string]any) {
+	delete(obj, "status")
+
+	if meta, ok := obj["metadata"].(map[string]any); ok {
+		sanitizeMeta(meta)
+	}
+
+	spec, _ := obj["spec"].(map[string]any)
+	if spec == nil {
+		return
+	}
+
+	switch obj["kind"] {
+	case "Deployment":
+		removeIfEqual(spec, "revisionHistoryLimit", 10)
+		removeIfEqual(spec, "progressDeadlineSeconds", 600)
+		if strategy, ok := spec["strategy"].(map[string]any); ok && strategy["type"] == "RollingUpdate" {
+			if ru, ok := strategy["rollingUpdate"].(map[string]any); ok &&
+				ru["maxSurge"] == "25%" && ru["maxUnavailable"] == "25%" {
+				delete(spec, "strategy")
+			}
+		}
+		sanitizePodTemplate(spec)
+	case "StatefulSet":
+		removeIfEqual(spec, "revisionHistoryLimit", 10)
+		removeIfEqual(spec, "podManagementPolicy", "OrderedReady")
+		if us, ok := spec["updateStrategy"].(map[string]any); ok && us["type"] == "RollingUpdate" {
+			if ru, ok := us["rollingUpdate"].(map[string]any); !ok || equal(ru["partition"], 0) {
+				delete(spec, "updateStrategy")
+			}
+		}
+		if p, ok := spec["persistentVolumeClaimRetentionPolicy"].(map[string]any); ok &&
+			p["whenDeleted"] == "Retain" && p["whenScaled"] == "Retain" {
+			delete(spec, "persistentVolumeClaimRetentionPolicy")
+		}
+		if tpls, ok := spec["volumeClaimTemplates"].([]any); ok {
+			for _, t := range tpls {
+				if tpl, ok := t.(map[string]any); ok {
+					delete(tpl, "status")
+					removeIfEqual(tpl, "apiVersion", "v1")
+					removeIfEqual(tpl, "kind", "PersistentVolumeClaim")
+					if m, ok := tpl["metadata"].(map[string]any); ok {
+						sanitizeMeta(m)
+					}
+					if s, ok := tpl["spec"].(map[string]any); ok {
+						removeIfEqual(s, "volumeMode", "Filesystem")
+					}
+				}
+			}
+		}
+		sanitizePodTemplate(spec)
+	case "DaemonSet":
+		removeIfEqual(spec, "revisionHistoryLimit", 10)
+		sanitizePodTemplate(spec)
+	case "CronJob":
+		removeIfEqual(spec, "concurrencyPolicy", "Allow")
+		removeIfEqual(spec, "successfulJobsHistoryLimit", 3)
+		removeIfEqual(spec, "failedJobsHistoryLimit", 1)
+		removeIfEqual(spec, "suspend", false)
+		if jt, ok := spec["jobTemplate"].(map[string]any); ok {
+			if m, ok := jt["metadata"].(map[string]any); ok {
+				sanitizeMeta(m)
+			}
+			if js, ok := jt["spec"].(map[string]any); ok {
+				sanitizePodTemplate(js)
+			}
+		}
+	case "Service":
+		sanitizeService(spec)
+	}
+}
+
+func sanitizeMeta(meta map[string]any) {
+	for _, k := range []string{"uid", "resourceVersion", "generation", "creationTimestamp",
+		"managedFields", "selfLink", "deletionTimestamp", "deletionGracePeriodSeconds", "ownerReferences"} {
+		delete(meta, k)
+	}
+
+	if annotations, ok := meta["annotations"].(map[string]any); ok {
+		for _, k := range runtimeAnnotations {
+			delete(annotations, k)
+		}
+	}
+	removeIfEmpty(meta, "annotations")
+	removeIfEmpty(meta, "labels")
+}
+
+func sanitizeService(spec map[string]any) {
+	if spec["clusterIP"] != "None" {
+		delete(spec, "clusterIP")
+		delete(spec, "clusterIPs")
+	}
+	delete(spec, "healthCheckNodePort")
+	removeIfEqual(spec, "type", "ClusterIP")
+	removeIfEqual(spec, "sessionAffinity", "None")
+	removeIfEqual(spec, "ipFamilyPolicy", "SingleStack")
+	removeIfEqual(spec, "internalTrafficPolicy", "Cluster")
+	if policy, ok := spec["ipFamilyPolicy"]; !ok || policy == "SingleStack" {
+		delete(spec, "ipFamilies")
+	}
+
+	if ports, ok := spec["ports"].([]any); ok {
+		for _, p := range ports {
+			if port, ok := p.(map[string]any); ok {
+				delete(port, "nodePort")
+				removeIfEqual(port, "protocol", "TCP")
+			}
+		}
+	}
+}
+
+// sanitizePodTemplate strip defaults of spec.template of a workload
+func sanitizePodTemplate(spec map[string]any) {
+	tpl, ok := spec["template"].(map[string]any)
+	if !ok {
+		return
+	}
+	if m, ok := tpl["metadata"].(map[string]any); ok {
+		delete(m, "creationTimestamp")
+		removeIfEmpty(m, "annotations")
+		removeIfEmpty(m, "labels")
+		removeIfEmpty(tpl, "metadata")
+	}
+
+	podSpec, ok := tpl["spec"].(map[string]any)
+	if !ok {
+		return
+	}
+	removeIfEqual(podSpec, "dnsPolicy", "ClusterFirst")
+	removeIfEqual(podSpec, "restartPolicy", "Always")
+	removeIfEqual(podSpec, "schedulerName", "default-scheduler")
+	removeIfEqual(podSpec, "terminationGracePeriodSeconds", 30)
+	removeIfEqual(podSpec, "serviceAccount", podSpec["serviceAccountName"])
+	removeIfEmpty(podSpec, "securityContext")
+
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, ok := podSpec[key].([]any)
+		if !ok {
+			continue
+		}
+		for _, c := range containers {
+			if container, ok := c.(map[string]any); ok {
+				sanitizeContainer(container)
+			}
+		}
+	}
+
+	if volumes, ok := podSpec["volumes"].([]any); ok {
+		for _, v := range volumes {
+			volume, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, source := range []string{"configMap", "secret"} {
+				if s, ok := volume[source].(map[string]any); ok {
+					removeIfEqual(s, "defaultMode", 420)
+				}
+			}
+		}
+	}
+}
+
+func sanitizeContainer(c map[string]any) {
+	removeIfEqual(c, "terminationMessagePath", "/dev/termination-log")
+	removeIfEqual(c, "terminationMessagePolicy", "File")
+	removeIfEmpty(c, "resources")
+
+	// imagePullPolicy is defaulted from the image tag
+	image, _ := c["image"].(string)
+	if strings.HasSuffix(image, ":latest") || !strings.Contains(imageTagPart(image), ":") {
+		removeIfEqual(c, "imagePullPolicy", "Always")
+	} else {
+		removeIfEqual(c, "imagePullPolicy", "IfNotPresent")
+	}
+
+	if ports, ok := c["ports"].([]any); ok {
+		for _, p := range ports {
+			if port, ok := p.(map[string]any); ok {
+				removeIfEqual(port, "protocol", "TCP")
+			}
+		}
+	}
+
+	for _, key := range []string{"livenessProbe", "readinessProbe", "startupProbe"} {
+		probe, ok := c[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		removeIfEqual(probe, "timeoutSeconds", 1)
+		removeIfEqual(probe, "periodSeconds", 10)
+		removeIfEqual(probe, "successThreshold", 1)
+		removeIfEqual(probe, "failureThreshold", 3)
+		if h, ok := probe["httpGet"].(map[string]any); ok {
+			removeIfEqual(h, "scheme", "HTTP")
+		}
+	}
+}
+
+// imageTagPart is the image without registry host, the host may have a port
+func imageTagPart(image string) string {
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		return image[i+1:]
+	}
+	return image
+}
+
+func removeIfEqual(m map[string]any, key string, value any) {
+	if v, ok := m[key]; ok && equal(v, value) {
+		delete(m, key)
+	}
+}
+
+func removeIfEmpty(m map[string]any, key string) {
+	switch v := m[key].(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			delete(m, key)
+		}
+	case []any:
+		if len(v) == 0 {
+			delete(m, key)
+		}
+	}
+}
+
+// equal compare scalar values, numbers from json or from the unstructured converter may be int64 or float64
+func equal(a, b any) bool {
+	toFloat := func(v any) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int32:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		return fa == fb
+	}
+	if okA || okB {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,190 @@
+package manifest
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func parseYAML(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var obj map[string]any
+	if err := yaml.Unmarshal([]byte(s), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "deployment defaults and runtime metadata",
+			in: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+  uid: 123
+  resourceVersion: "42"
+  generation: 3
+  creationTimestamp: "2024-01-01T00:00:00Z"
+  managedFields: [{manager: kubectl}]
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+spec:
+  replicas: 2
+  revisionHistoryLimit: 10
+  progressDeadlineSeconds: 600
+  strategy:
+    type: RollingUpdate
+    rollingUpdate: {maxSurge: 25%, maxUnavailable: 25%}
+  template:
+    metadata:
+      creationTimestamp: null
+      labels: {app: web}
+    spec:
+      dnsPolicy: ClusterFirst
+      restartPolicy: Always
+      schedulerName: default-scheduler
+      terminationGracePeriodSeconds: 30
+      securityContext: {}
+      containers:
+      - name: app
+        image: nginx:1.25
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File
+        resources: {}
+        ports: [{containerPort: 80, protocol: TCP}]
+status:
+  replicas: 2
+`,
+			want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.25
+        ports: [{containerPort: 80}]
+`,
+		},
+		{
+			name: "non default values are kept",
+			in: `
+kind: Deployment
+metadata: {name: web}
+spec:
+  revisionHistoryLimit: 3
+  strategy:
+    type: RollingUpdate
+    rollingUpdate: {maxSurge: 1, maxUnavailable: 0}
+  template:
+    spec:
+      terminationGracePeriodSeconds: 60
+      containers:
+      - name: app
+        image: nginx
+        imagePullPolicy: IfNotPresent
+`,
+			want: `
+kind: Deployment
+metadata: {name: web}
+spec:
+  revisionHistoryLimit: 3
+  strategy:
+    type: RollingUpdate
+    rollingUpdate: {maxSurge: 1, maxUnavailable: 0}
+  template:
+    spec:
+      terminationGracePeriodSeconds: 60
+      containers:
+      - name: app
+        image: nginx
+        imagePullPolicy: IfNotPresent
+`,
+		},
+		{
+			name: "service allocated fields",
+			in: `
+kind: Service
+metadata: {name: web}
+spec:
+  type: ClusterIP
+  clusterIP: 10.0.0.1
+  clusterIPs: [10.0.0.1]
+  ipFamilies: [IPv4]
+  ipFamilyPolicy: SingleStack
+  sessionAffinity: None
+  internalTrafficPolicy: Cluster
+  ports: [{port: 80, protocol: TCP, nodePort: 30080}]
+`,
+			want: `
+kind: Service
+metadata: {name: web}
+spec:
+  ports: [{port: 80}]
+`,
+		},
+		{
+			name: "headless service keep clusterIP None",
+			in: `
+kind: Service
+metadata: {name: db}
+spec:
+  clusterIP: None
+  clusterIPs: [None]
+`,
+			want: `
+kind: Service
+metadata: {name: db}
+spec:
+  clusterIP: None
+  clusterIPs: [None]
+`,
+		},
+	}
+	for _, c := range cases {
+		obj := parseYAML(t, c.in)
+		Sanitize(obj)
+		if want := parseYAML(t, c.want); !reflect.DeepEqual(obj, want) {
+			got, _ := yaml.Marshal(obj)
+			t.Errorf("%s: got\n%s", c.name, got)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		a, b any
+		want bool
+	}{
+		{int64(10), 10, true},
+		{float64(10), 10, true},
+		{int32(600), float64(600), true},
+		{"10", 10, false},
+		{"ClusterFirst", "ClusterFirst", true},
+		{false, false, true},
+		{nil, 0, false},
+	}
+	for _, c := range cases {
+		if got := equal(c.a, c.b); got != c.want {
+			t.Errorf("equal(%#v, %#v) = %t, want %t", c.a, c.b, got, c.want)
+		}
+	}
+}
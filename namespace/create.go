@@ -0,0 +1,188 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/config"
+	"k8sctl/preview"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type NamespaceSpec struct {
+	Client *kubernetes.Clientset
+	Name   string
+	// namespace to copy imagePullSecrets from
+	Source string
+	Owner  string
+	TTL    time.Duration
+}
+
+// Ensure check the namespace exist, and create it from the namespace template when create = true,
+// a namespace k8sctl created before get the missing pieces of the template again, so a failed run can be rerun
+func (n *NamespaceSpec) Ensure(create bool) error {
+	ns, err := n.Client.CoreV1().Namespaces().Get(context.TODO(), n.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && (!create || ns.Annotations[preview.AnnotationCreatedNamespace] != "true") {
+		return nil
+	}
+	if err != nil && !create {
+		return fmt.Errorf("目标命名空间= %s 不存在, 请先创建或使用 --create-namespace", n.Name)
+	}
+
+	c, err := config.Load()
+	if err != nil {
+		log.Printf("Load config = %s err: %v", config.Path(), err)
+		return err
+	}
+	return n.create(&c.NamespaceTemplate)
+}
+
+func (n *NamespaceSpec) create(tpl *config.NamespaceTemplate) error {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        n.Name,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string),
+		},
+	}
+	for k, v := range tpl.Labels {
+		ns.Labels[k] = v
+	}
+	for k, v := range tpl.Annotations {
+		ns.Annotations[k] = v
+	}
+	m := &preview.Mark{
+		Owner:  n.Owner,
+		Source: n.Source,
+		Copy:   n.Name,
+		TTL:    n.TTL,
+	}
+	m.Annotate(&ns.ObjectMeta)
+	// the namespace itself is not a copy, gc find it by the created annotation
+	delete(ns.Annotations, preview.AnnotationCopy)
+	ns.Annotations[preview.AnnotationCreatedNamespace] = "true"
+
+	if _, err := n.Client.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			log.Printf("Create namespace = %s err: %v", n.Name, err)
+			return err
+		}
+		log.Printf("Namespace = %s already exist, check the objects of namespace template.", n.Name)
+	} else {
+		log.Printf("Create namespace = %s complete.", n.Name)
+	}
+
+	if tpl.ResourceQuota != nil {
+		quota := &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "k8sctl-quota", Namespace: n.Name},
+			Spec:       *tpl.ResourceQuota,
+		}
+		if _, err := n.Client.CoreV1().ResourceQuotas(n.Name).Create(context.TODO(), quota, metav1.CreateOptions{}); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				log.Printf("Create resourcequota = %s, namespace = %s err: %v", quota.Name, n.Name, err)
+				return err
+			}
+		} else {
+			log.Printf("Create resourcequota = %s, namespace = %s complete.", quota.Name, n.Name)
+		}
+	}
+
+	if tpl.LimitRange != nil {
+		limits := &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "k8sctl-limits", Namespace: n.Name},
+			Spec:       *tpl.LimitRange,
+		}
+		if _, err := n.Client.CoreV1().LimitRanges(n.Name).Create(context.TODO(), limits, metav1.CreateOptions{}); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				log.Printf("Create limitrange = %s, namespace = %s err: %v", limits.Name, n.Name, err)
+				return err
+			}
+		} else {
+			log.Printf("Create limitrange = %s, namespace = %s complete.", limits.Name, n.Name)
+		}
+	}
+
+	for _, np := range tpl.NetworkPolicies {
+		policy := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: np.Name, Namespace: n.Name},
+			Spec:       np.Spec,
+		}
+		if _, err := n.Client.NetworkingV1().NetworkPolicies(n.Name).Create(context.TODO(), policy, metav1.CreateOptions{}); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				log.Printf("Create networkpolicy = %s, namespace = %s err: %v", np.Name, n.Name, err)
+				return err
+			}
+		} else {
+			log.Printf("Create networkpolicy = %s, namespace = %s complete.", np.Name, n.Name)
+		}
+	}
+
+	if len(tpl.ImagePullSecrets) > 0 {
+		return n.addImagePullSecrets(tpl.ImagePullSecrets)
+	}
+	return nil
+}
+
+// addImagePullSecrets copy the secrets from Source and add them to the default serviceaccount
+func (n *NamespaceSpec) addImagePullSecrets(names []string) error {
+	for _, name := range names {
+		secret, err := n.Client.CoreV1().Secrets(n.Source).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Get imagePullSecret = %s, namespace = %s err: %v", name, n.Source, err)
+			return err
+		}
+		newSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: n.Name},
+			Type:       secret.Type,
+			Data:       secret.Data,
+		}
+		if _, err := n.Client.CoreV1().Secrets(n.Name).Create(context.TODO(), newSecret, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+			log.Printf("Create imagePullSecret = %s, namespace = %s err: %v", name, n.Name, err)
+			return err
+		}
+	}
+
+	// default serviceaccount is created by controller-manager a moment after the namespace
+	for i := 0; i < 30; i++ {
+		sa, err := n.Client.CoreV1().ServiceAccounts(n.Name).Get(context.TODO(), "default", metav1.GetOptions{})
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+		before := sa.DeepCopy()
+		added := make(map[string]bool)
+		for _, ref := range sa.ImagePullSecrets {
+			added[ref.Name] = true
+		}
+		var missing []string
+		for _, name := range names {
+			if !added[name] {
+				missing = append(missing, name)
+				sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if _, err := backup.BeforeMutation("create namespace", before); err != nil {
+			return err
+		}
+		if _, err := n.Client.CoreV1().ServiceAccounts(n.Name).Update(context.TODO(), sa, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Update serviceaccount = default, namespace = %s err: %v", n.Name, err)
+			return err
+		}
+		log.Printf("Add imagePullSecrets = %v to serviceaccount = default, namespace = %s complete.", missing, n.Name)
+		return nil
+	}
+	return fmt.Errorf("wait serviceaccount = default in namespace = %s timeout", n.Name)
+}
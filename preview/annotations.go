@@ -0,0 +1,66 @@
+package preview
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// who triggered the copy, normally the ci user
+	AnnotationOwner = "k8sctl.io/owner"
+	// source object of the copy, format: namespace/name
+	AnnotationSource = "k8sctl.io/source"
+	// copy name, all objects created by one copy share it
+	AnnotationCopy = "k8sctl.io/copy"
+	// RFC3339 time after which gc may delete the copy
+	AnnotationExpiresAt = "k8sctl.io/expires-at"
+	// set on namespaces created by k8sctl, gc may remove them
+	AnnotationCreatedNamespace = "k8sctl.io/created-namespace"
+)
+
+type Mark struct {
+	Owner  string
+	Source string
+	Copy   string
+	TTL    time.Duration
+}
+
+// Annotate writes the ownership annotations of a copy to meta,
+// an expiry is only written when TTL > 0
+func (m *Mark) Annotate(meta *metav1.ObjectMeta) {
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[AnnotationOwner] = m.Owner
+	meta.Annotations[AnnotationSource] = m.Source
+	meta.Annotations[AnnotationCopy] = m.Copy
+
+	if m.TTL > 0 {
+		meta.Annotations[AnnotationExpiresAt] = time.Now().Add(m.TTL).UTC().Format(time.RFC3339)
+	} else {
+		delete(meta.Annotations, AnnotationExpiresAt)
+	}
+}
+
+// RefuseNotCopy stop replacing or deleting an existing object in the target namespace which k8sctl copy not create
+func RefuseNotCopy(kind string, obj metav1.Object) error {
+	if _, ok := obj.GetAnnotations()[AnnotationCopy]; !ok {
+		return fmt.Errorf("%s = %s, namespace = %s is not created by k8sctl copy, refuse to replace it", kind, obj.GetName(), obj.GetNamespace())
+	}
+	return nil
+}
+
+// Expired report whether annotations carry an expiry in the past
+func Expired(annotations map[string]string, now time.Time) bool {
+	v, ok := annotations[AnnotationExpiresAt]
+	if !ok {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return false
+	}
+	return now.After(t)
+}
@@ -0,0 +1,54 @@
+package preview
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRefuseNotCopy(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "copy", annotations: map[string]string{AnnotationCopy: "api"}},
+		{name: "copy without ttl", annotations: map[string]string{AnnotationCopy: "", AnnotationOwner: "ci"}},
+		{name: "no annotations", wantErr: true},
+		{name: "source only", annotations: map[string]string{AnnotationSource: "dev/tls"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &metav1.ObjectMeta{Name: "api", Namespace: "pr-1", Annotations: tt.annotations}
+			if err := RefuseNotCopy("statefulset", obj); (err != nil) != tt.wantErr {
+				t.Errorf("RefuseNotCopy err = %v, want err %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		expiresAt string
+		want      bool
+	}{
+		{name: "no expiry"},
+		{name: "past", expiresAt: "2026-10-01T11:00:00Z", want: true},
+		{name: "future", expiresAt: "2026-10-01T13:00:00Z"},
+		{name: "broken", expiresAt: "tomorrow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.expiresAt != "" {
+				annotations[AnnotationExpiresAt] = tt.expiresAt
+			}
+			if got := Expired(annotations, now); got != tt.want {
+				t.Errorf("Expired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
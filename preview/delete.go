@@ -0,0 +1,202 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Cleanup delete every object of one copy, found by the k8sctl.io/copy annotation
+type Cleanup struct {
+	Client    *kubernetes.Clientset
+	Namespace string
+	// copy name, the deployment name for copy deployment, the app for copy app
+	Copy   string
+	DryRun bool
+	// seconds to wait for the pods of the copy terminated
+	Timeout int
+}
+
+// Run delete the copy and wait for its pods gone, return the deleted objects
+func (c *Cleanup) Run() ([]Object, error) {
+	if err := c.refuseNotCreated(); err != nil {
+		return nil, err
+	}
+
+	all, err := ListCopies(c.Client, c.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var objs []Object
+	for _, obj := range all {
+		if obj.Annotations[AnnotationCopy] == c.Copy {
+			objs = append(objs, obj)
+		}
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("not found any object created by k8sctl copy = %s in namespace = %s", c.Copy, c.Namespace)
+	}
+
+	if c.DryRun {
+		for _, obj := range objs {
+			if err := DryRunDelete(c.Client, obj); err != nil {
+				return nil, err
+			}
+		}
+		return objs, nil
+	}
+
+	if _, err := backup.BeforeMutation("delete deployment", raws(objs)...); err != nil {
+		return nil, err
+	}
+
+	pods, err := c.copyPods(objs)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []Object
+	for _, obj := range objs {
+		if err := Delete(c.Client, obj); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, obj)
+	}
+
+	return deleted, c.waitPodsGone(pods)
+}
+
+// refuseNotCreated stop when a deployment, statefulset or cronjob with the copy name exist but k8sctl not create it
+func (c *Cleanup) refuseNotCreated() error {
+	for _, k := range kinds {
+		if !k.workload {
+			continue
+		}
+		list, err := k.list(c.Client, c.Namespace)
+		if errors.IsForbidden(err) {
+			log.Printf("WARN: list %s in namespace = %s forbidden, skip checking it: %v", k.name, c.Namespace, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("List %s in namespace = %s err: %v", k.name, c.Namespace, err)
+			return err
+		}
+		for _, o := range list {
+			m, err := meta.Accessor(o)
+			if err != nil {
+				return err
+			}
+			if m.GetName() != c.Copy {
+				continue
+			}
+			if _, ok := m.GetAnnotations()[AnnotationCopy]; !ok {
+				return fmt.Errorf("%s = %s, namespace = %s is not created by k8sctl copy, refuse to delete it", k.name, c.Copy, c.Namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// copyPods return the name of every pod the deployments and statefulsets in objs control, by uid,
+// so pods of other workloads with the same labels are not waited for
+func (c *Cleanup) copyPods(objs []Object) (map[types.UID]string, error) {
+	pods := make(map[types.UID]string)
+	for _, obj := range objs {
+		var selector *metav1.LabelSelector
+		var deployUID types.UID
+		// uid of the pod controllers
+		owners := make(map[types.UID]bool)
+		switch raw := obj.Raw.(type) {
+		case *appsv1.Deployment:
+			selector, deployUID = raw.Spec.Selector, raw.UID
+		case *appsv1.StatefulSet:
+			selector = raw.Spec.Selector
+			owners[raw.UID] = true
+		default:
+			continue
+		}
+		s, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		opts := metav1.ListOptions{LabelSelector: s.String()}
+
+		// pods of a deployment are controlled by its replicasets
+		if deployUID != "" {
+			rss, err := c.Client.AppsV1().ReplicaSets(obj.Namespace).List(context.TODO(), opts)
+			if err != nil {
+				return nil, err
+			}
+			for i := range rss.Items {
+				if ref := metav1.GetControllerOf(&rss.Items[i]); ref != nil && ref.UID == deployUID {
+					owners[rss.Items[i].UID] = true
+				}
+			}
+		}
+		if len(owners) == 0 {
+			continue
+		}
+
+		list, err := c.Client.CoreV1().Pods(obj.Namespace).List(context.TODO(), opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			if ref := metav1.GetControllerOf(&list.Items[i]); ref != nil && owners[ref.UID] {
+				pods[list.Items[i].UID] = list.Items[i].Name
+			}
+		}
+	}
+	return pods, nil
+}
+
+// waitPodsGone wait until every pod in pods is deleted, a pod recreated with the same name has another uid
+func (c *Cleanup) waitPodsGone(pods map[types.UID]string) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 180
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+
+	log.Printf("等待 %d 个 pod 终止", len(pods))
+	for i := 0; ; i++ {
+		for uid, name := range pods {
+			p, err := c.Client.CoreV1().Pods(c.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if errors.IsNotFound(err) || (err == nil && p.UID != uid) {
+				delete(pods, uid)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(pods) == 0 {
+			fmt.Println()
+			return nil
+		}
+		if i >= timeout {
+			var names []string
+			for _, name := range pods {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("等待 %d 秒 pod %s 仍未终止, 请检查", timeout, strings.Join(names, ","))
+		}
+		if i%3 == 0 {
+			fmt.Printf(".")
+		}
+		time.Sleep(time.Second)
+	}
+}
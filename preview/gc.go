@@ -0,0 +1,111 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"k8sctl/backup"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type GC struct {
+	Client *kubernetes.Clientset
+	// namespace to check, "all" for every namespace
+	Namespace string
+	// also delete namespaces created by k8sctl once nothing alive is left
+	DeleteNamespace bool
+	DryRun          bool
+}
+
+// Run delete every expired copy, return the deleted objects
+func (g *GC) Run() ([]Object, error) {
+	ns := g.Namespace
+	if ns == "" || ns == "all" {
+		ns = metav1.NamespaceAll
+	}
+
+	objs, err := ListCopies(g.Client, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var expired, deleted []Object
+	// namespace -> whether every copy in it has expired
+	allExpired := make(map[string]bool)
+
+	for _, obj := range objs {
+		if !Expired(obj.Annotations, now) {
+			allExpired[obj.Namespace] = false
+			continue
+		}
+		if _, ok := allExpired[obj.Namespace]; !ok {
+			allExpired[obj.Namespace] = true
+		}
+
+		log.Printf("Expired %s = %s, namespace = %s, owner = %s, expires-at = %s", obj.Kind, obj.Name, obj.Namespace,
+			obj.Annotations[AnnotationOwner], obj.Annotations[AnnotationExpiresAt])
+		expired = append(expired, obj)
+	}
+	if g.DryRun {
+		deleted = expired
+	} else {
+		if _, err := backup.BeforeMutation("gc previews", raws(expired)...); err != nil {
+			return nil, err
+		}
+		for _, obj := range expired {
+			if err := Delete(g.Client, obj); err != nil {
+				allExpired[obj.Namespace] = false
+				continue
+			}
+			deleted = append(deleted, obj)
+		}
+	}
+
+	if !g.DeleteNamespace {
+		return deleted, nil
+	}
+
+	for name, expired := range allExpired {
+		if !expired {
+			continue
+		}
+		if err := g.deleteCreatedNamespace(name); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (g *GC) deleteCreatedNamespace(name string) error {
+	ns, err := g.Client.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Get namespace = %s err: %v", name, err)
+		return err
+	}
+	if ns.Annotations[AnnotationCreatedNamespace] != "true" {
+		log.Printf("Namespace = %s not created by k8sctl, skip delete", name)
+		return nil
+	}
+
+	log.Printf("Namespace = %s created by k8sctl and all copies expired, delete it", name)
+	if g.DryRun {
+		return nil
+	}
+
+	if err := g.Client.CoreV1().Namespaces().Delete(context.TODO(), name, metav1.DeleteOptions{
+		DryRun: []string{metav1.DryRunAll},
+	}); err != nil {
+		log.Printf("Dryrun delete namespace = %s err: %v", name, err)
+		return err
+	}
+	// what is left in the namespace, e.g. secrets and configmaps not copied by k8sctl
+	if _, err := backup.SnapshotNamespace(g.Client, name, false); err != nil && !errors.Is(err, backup.ErrEmptyNamespace) {
+		log.Printf("Backup namespace = %s err: %v, skip delete", name, err)
+		return err
+	}
+	return g.Client.CoreV1().Namespaces().Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
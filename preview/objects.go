@@ -0,0 +1,281 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Object is one k8s object created by a copy
+type Object struct {
+	Kind        string            `json:"kind"`
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// the listed object, backed up before it is deleted
+	Raw runtime.Object `json:"-"`
+}
+
+type kind struct {
+	name string
+	// deployment, statefulset and cronjob are named after the copy
+	workload bool
+	list     func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error)
+	delete   func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error
+}
+
+// workloads first, so no pod is left behind without its service
+var kinds = []kind{
+	{
+		name:     "Deployment",
+		workload: true,
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.AppsV1().Deployments(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.AppsV1().Deployments(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name:     "StatefulSet",
+		workload: true,
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.AppsV1().StatefulSets(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.AppsV1().StatefulSets(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name:     "CronJob",
+		workload: true,
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.BatchV1().CronJobs(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.BatchV1().CronJobs(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name: "HorizontalPodAutoscaler",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.AutoscalingV2().HorizontalPodAutoscalers(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.AutoscalingV2().HorizontalPodAutoscalers(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name: "PodDisruptionBudget",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.PolicyV1().PodDisruptionBudgets(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.PolicyV1().PodDisruptionBudgets(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name: "Ingress",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.NetworkingV1().Ingresses(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.NetworkingV1().Ingresses(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name: "Service",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.CoreV1().Services(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.CoreV1().Services(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		name: "ConfigMap",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.CoreV1().ConfigMaps(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.CoreV1().ConfigMaps(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+	{
+		// tls secrets of copied ingresses
+		name: "Secret",
+		list: func(cs *kubernetes.Clientset, ns string) ([]runtime.Object, error) {
+			l, err := cs.CoreV1().Secrets(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]runtime.Object, 0, len(l.Items))
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+			return objs, nil
+		},
+		delete: func(cs *kubernetes.Clientset, ns, name string, opts metav1.DeleteOptions) error {
+			return cs.CoreV1().Secrets(ns).Delete(context.TODO(), name, opts)
+		},
+	},
+}
+
+// ListCopies return every object in ns which has the copy annotation,
+// ns = metav1.NamespaceAll for all namespaces
+func ListCopies(cs *kubernetes.Clientset, ns string) ([]Object, error) {
+	var objs []Object
+	for _, k := range kinds {
+		list, err := k.list(cs, ns)
+		if errors.IsForbidden(err) {
+			log.Printf("WARN: list %s in namespace = %s forbidden, skip copies of this kind: %v", k.name, ns, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("List %s in namespace = %s err: %v", k.name, ns, err)
+			return nil, err
+		}
+		for _, o := range list {
+			m, err := meta.Accessor(o)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := m.GetAnnotations()[AnnotationCopy]; !ok {
+				continue
+			}
+			objs = append(objs, Object{
+				Kind:        k.name,
+				Namespace:   m.GetNamespace(),
+				Name:        m.GetName(),
+				Annotations: m.GetAnnotations(),
+				Raw:         o,
+			})
+		}
+	}
+	return objs, nil
+}
+
+// DryRunDelete only run a server dry-run delete
+func DryRunDelete(cs *kubernetes.Clientset, obj Object) error {
+	for _, k := range kinds {
+		if k.name != obj.Kind {
+			continue
+		}
+
+		err := k.delete(cs, obj.Namespace, obj.Name, metav1.DeleteOptions{
+			DryRun: []string{metav1.DryRunAll},
+		})
+		if err != nil {
+			log.Printf("Dryrun delete %s = %s, namespace = %s err: %s\n", obj.Kind, obj.Name, obj.Namespace, err)
+			return err
+		}
+		log.Printf("Dryrun delete %s = %s, namespace = %s successfully.\n", obj.Kind, obj.Name, obj.Namespace)
+		return nil
+	}
+	return fmt.Errorf("unsupported kind %s", obj.Kind)
+}
+
+// Delete run a server dry-run delete first, and only delete the object when it pass
+func Delete(cs *kubernetes.Clientset, obj Object) error {
+	if err := DryRunDelete(cs, obj); err != nil {
+		return err
+	}
+
+	for _, k := range kinds {
+		if k.name != obj.Kind {
+			continue
+		}
+
+		var graceTimeout int64 = 40
+		if err := k.delete(cs, obj.Namespace, obj.Name, metav1.DeleteOptions{
+			GracePeriodSeconds: &graceTimeout,
+		}); err != nil {
+			log.Printf("Delete %s = %s, namespace = %s err: %s\n", obj.Kind, obj.Name, obj.Namespace, err)
+			return err
+		}
+		log.Printf("Delete %s = %s, namespace = %s successfully.\n", obj.Kind, obj.Name, obj.Namespace)
+		return nil
+	}
+	return fmt.Errorf("unsupported kind %s", obj.Kind)
+}
+
+// raws return the listed objects of objs, for backup
+func raws(objs []Object) []runtime.Object {
+	var list []runtime.Object
+	for _, obj := range objs {
+		if obj.Raw != nil {
+			list = append(list, obj.Raw)
+		}
+	}
+	return list
+}
@@ -0,0 +1,131 @@
+package printer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	Table    = "table"
+	Wide     = "wide"
+	JSON     = "json"
+	YAML     = "yaml"
+	Markdown = "markdown"
+
+	// APIVersion of the json and yaml output, bumped when a field of an item is renamed or removed
+	APIVersion = "k8sctl.io/v1"
+)
+
+// Column is one column of table output
+type Column struct {
+	Name string
+	// only printed by wide and markdown
+	Wide bool
+}
+
+// List is the json and yaml output, kind name the schema of items
+type List struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Items      any    `json:"items"`
+}
+
+// Printer print the result of get-style commands
+type Printer struct {
+	Format string
+	Out    io.Writer
+}
+
+func New(format string, out io.Writer) (*Printer, error) {
+	switch format {
+	case Table, Wide, JSON, YAML, Markdown:
+		return &Printer{Format: format, Out: out}, nil
+	}
+	return nil, fmt.Errorf("--output = %s not match table|json|yaml|wide|markdown", format)
+}
+
+// Human report whether the output is a table for people, an empty result is better told by a log line then
+func (p *Printer) Human() bool {
+	return p.Format == Table || p.Format == Wide
+}
+
+// Print write items as json or yaml, or rows under columns for the table formats.
+// items is a slice of structs with json tags, rows has one cell per column
+func (p *Printer) Print(kind string, items any, columns []Column, rows [][]string) error {
+	switch p.Format {
+	case JSON:
+		enc := json.NewEncoder(p.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(List{APIVersion: APIVersion, Kind: kind, Items: nonNil(items)})
+	case YAML:
+		data, err := yaml.Marshal(List{APIVersion: APIVersion, Kind: kind, Items: nonNil(items)})
+		if err != nil {
+			return err
+		}
+		_, err = p.Out.Write(data)
+		return err
+	case Markdown:
+		return p.markdown(columns, rows)
+	}
+
+	w := tabwriter.NewWriter(p.Out, 0, 4, 2, ' ', 0)
+	var header []string
+	for _, c := range columns {
+		if c.Wide && p.Format != Wide {
+			continue
+		}
+		header = append(header, c.Name)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		var cells []string
+		for i, c := range columns {
+			if c.Wide && p.Format != Wide {
+				continue
+			}
+			cells = append(cells, oneLine(row[i]))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func (p *Printer) markdown(columns []Column, rows [][]string) error {
+	var b strings.Builder
+	var header, sep []string
+	for _, c := range columns {
+		header = append(header, c.Name)
+		sep = append(sep, "---")
+	}
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString("| " + strings.Join(sep, " | ") + " |\n")
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = strings.ReplaceAll(oneLine(cell), "|", "\\|")
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	_, err := io.WriteString(p.Out, b.String())
+	return err
+}
+
+// oneLine keep a cell on one line, e.g. a multi-line container message
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// nonNil make a nil slice an empty list in json and yaml
+func nonNil(items any) any {
+	v := reflect.ValueOf(items)
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return []any{}
+	}
+	return items
+}
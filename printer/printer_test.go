@@ -0,0 +1,63 @@
+package printer
+
+import (
+	"bytes"
+	"testing"
+)
+
+type item struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func TestPrint(t *testing.T) {
+	columns := []Column{{Name: "NAME"}, {Name: "STATUS", Wide: true}}
+	items := []item{{Name: "api", Status: "ok"}}
+	rows := [][]string{{"api", "ok\nfine"}}
+
+	tests := []struct {
+		format string
+		items  []item
+		want   string
+	}{
+		{format: Table, items: items, want: "NAME\napi\n"},
+		{format: Wide, items: items, want: "NAME  STATUS\napi   ok fine\n"},
+		{format: Markdown, items: items, want: "| NAME | STATUS |\n| --- | --- |\n| api | ok fine |\n"},
+		{format: JSON, items: items, want: `{
+  "apiVersion": "k8sctl.io/v1",
+  "kind": "ItemList",
+  "items": [
+    {
+      "name": "api",
+      "status": "ok"
+    }
+  ]
+}
+`},
+		{format: YAML, items: items, want: "apiVersion: k8sctl.io/v1\nitems:\n- name: api\n  status: ok\nkind: ItemList\n"},
+		{format: JSON, want: "{\n  \"apiVersion\": \"k8sctl.io/v1\",\n  \"kind\": \"ItemList\",\n  \"items\": []\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := New(tt.format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := rows
+			if tt.items == nil {
+				r = nil
+			}
+			if err := p.Print("ItemList", tt.items, columns, r); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+
+	if _, err := New("text", &bytes.Buffer{}); err == nil {
+		t.Error("New accept an unknown format")
+	}
+}
@@ -0,0 +1,337 @@
+package statefulset
+
+import (
+	"context"
+	"fmt"
+	"k8sctl/backup"
+	"k8sctl/preview"
+	"k8sctl/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type StatefulSetSpec struct {
+	Client       *kubernetes.Clientset
+	Name         string
+	Namespace    string
+	NewNamespace string
+	ImageTag     string
+	Replicas     int32
+	Owner        string
+	TTL          time.Duration
+	// written to k8sctl.io/copy annotation, default Name
+	CopyName string
+}
+
+func NewStatefulSet(client *kubernetes.Clientset) *StatefulSetSpec {
+
+	return &StatefulSetSpec{
+		Client: client,
+	}
+}
+
+// CreateNew copy statefulset and its governing service from Namespace to NewNamespace,
+// pvc of an existing copy are deleted, so the new pods get fresh volumes from volumeClaimTemplates
+func (s *StatefulSetSpec) CreateNew() error {
+	if err := s.checkTarget(); err != nil {
+		return err
+	}
+	log.Println("Copy StatefulSet ...")
+	src, err := s.Client.AppsV1().StatefulSets(s.Namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("在命名空间= %s 没有发现 statefulset= %s, 请先部署到命名空间 %s,再重试", s.Namespace, s.Name, s.Namespace)
+	}
+	log.Printf("StatefulSet = %s, namespace = %s has found. Continue ...", s.Name, s.Namespace)
+
+	// copy governing service
+	if src.Spec.ServiceName != "" {
+		if err := s.copySvc(src.Spec.ServiceName); err != nil {
+			return err
+		}
+	}
+
+	if err := s.createNew(src); err != nil {
+		return err
+	}
+
+	if err := WaitStatefulSetUpdate(s.Client, s.NewNamespace, s.Name, 300); err != nil {
+		log.Printf("wait for pod running err: %s\n", err)
+		return err
+	}
+	log.Println("Pod running successfully!")
+
+	return nil
+}
+
+// CopyStatefulSet copy only the statefulset without its service and without waiting for it
+func (s *StatefulSetSpec) CopyStatefulSet() error {
+	if err := s.checkTarget(); err != nil {
+		return err
+	}
+	log.Println("Copy StatefulSet ...")
+	src, err := s.Client.AppsV1().StatefulSets(s.Namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("在命名空间= %s 没有发现 statefulset= %s, 请先部署到命名空间 %s,再重试", s.Namespace, s.Name, s.Namespace)
+	}
+	return s.createNew(src)
+}
+
+// checkTarget refuse copying into the source namespace, the copy would replace the source and wipe its volumes
+func (s *StatefulSetSpec) checkTarget() error {
+	if s.Namespace == s.NewNamespace {
+		return fmt.Errorf("copy statefulset = %s to the same namespace = %s is not supported", s.Name, s.Namespace)
+	}
+	return nil
+}
+
+func (s *StatefulSetSpec) createNew(src *appsv1.StatefulSet) error {
+	dst, err := s.Client.AppsV1().StatefulSets(s.NewNamespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		// its pvc are deleted below, never touch a statefulset k8sctl not create
+		if err := preview.RefuseNotCopy("statefulset", dst); err != nil {
+			return err
+		}
+		log.Printf("StatefulSet = %s, namespace = %s has found. Recreating it ...", s.Name, s.NewNamespace)
+		if _, err := backup.BeforeMutation("copy statefulset", dst); err != nil {
+			return err
+		}
+		if ok := s.DeleteNew(); !ok {
+			return fmt.Errorf("delete statefulset = %s, namespace = %s failed", s.Name, s.NewNamespace)
+		}
+		// new pods must not bind to the claims of the terminating ones
+		if err := s.waitGone(dst); err != nil {
+			return err
+		}
+		if err := s.deletePVCs(dst); err != nil {
+			return err
+		}
+	}
+
+	newSts := s.newStatefulSet(src)
+	if _, err := s.Client.AppsV1().StatefulSets(s.NewNamespace).Create(context.TODO(), newSts, metav1.CreateOptions{}); err != nil {
+		log.Printf("Create statefulset = %s, namespace = %s err %s\n", s.Name, s.NewNamespace, err)
+		return err
+	}
+	log.Printf("Create statefulset = %s, namespace = %s complete.\n", s.Name, s.NewNamespace)
+	return nil
+}
+
+func (s *StatefulSetSpec) newStatefulSet(src *appsv1.StatefulSet) *appsv1.StatefulSet {
+	sts := src.DeepCopy()
+	sts.Namespace = s.NewNamespace
+	sts.ResourceVersion = ""
+	sts.UID = ""
+	sts.ManagedFields = nil
+	sts.Status = appsv1.StatefulSetStatus{}
+	sts.Spec.Replicas = &s.Replicas
+
+	for i := range sts.Spec.VolumeClaimTemplates {
+		sts.Spec.VolumeClaimTemplates[i].Spec.VolumeName = ""
+		sts.Spec.VolumeClaimTemplates[i].Status = corev1.PersistentVolumeClaimStatus{}
+	}
+
+	containers := sts.Spec.Template.Spec.Containers
+	if s.ImageTag != "" && len(containers) > 0 {
+		containers[0].Image = utils.ReplaceImageTag(containers[0].Image, s.ImageTag)
+	}
+
+	s.markCopy(&sts.ObjectMeta)
+	return sts
+}
+
+func (s *StatefulSetSpec) markCopy(meta *metav1.ObjectMeta) {
+	copyName := s.CopyName
+	if copyName == "" {
+		copyName = s.Name
+	}
+	m := &preview.Mark{
+		Owner:  s.Owner,
+		Source: s.Namespace + "/" + s.Name,
+		Copy:   copyName,
+		TTL:    s.TTL,
+	}
+	m.Annotate(meta)
+}
+
+// copySvc copy the governing service, a headless service stay headless
+func (s *StatefulSetSpec) copySvc(name string) error {
+	log.Println("Copy Service ...")
+	svc, err := s.Client.CoreV1().Services(s.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("INFO: Service = %s, namespace = %s not found, skip copy service.\n", name, s.Namespace)
+		return nil
+	}
+
+	newSvc := svc.DeepCopy()
+	newSvc.Namespace = s.NewNamespace
+	newSvc.ResourceVersion = ""
+	newSvc.UID = ""
+	newSvc.ManagedFields = nil
+	newSvc.Status = corev1.ServiceStatus{}
+	newSvc.Spec.Type = corev1.ServiceTypeClusterIP
+	newSvc.Spec.ExternalTrafficPolicy = ""
+	if newSvc.Spec.ClusterIP != corev1.ClusterIPNone {
+		newSvc.Spec.ClusterIP = ""
+		newSvc.Spec.ClusterIPs = nil
+	}
+	for k := range newSvc.Spec.Ports {
+		newSvc.Spec.Ports[k].NodePort = 0
+	}
+	s.markCopy(&newSvc.ObjectMeta)
+
+	dst, err := s.Client.CoreV1().Services(s.NewNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if err := preview.RefuseNotCopy("service", dst); err != nil {
+			return err
+		}
+		log.Printf("Service = %s, namespace = %s has found. Recreating it ...", name, s.NewNamespace)
+		if _, err := backup.BeforeMutation("copy statefulset", dst); err != nil {
+			return err
+		}
+		if err := s.Client.CoreV1().Services(s.NewNamespace).Delete(context.TODO(), name, metav1.DeleteOptions{
+			DryRun: []string{metav1.DryRunAll},
+		}); err != nil {
+			log.Printf("DryRun delete svc = %s, namespace = %s error: %s\n", name, s.NewNamespace, err)
+			return err
+		}
+		_ = s.Client.CoreV1().Services(s.NewNamespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	}
+
+	if _, err := s.Client.CoreV1().Services(s.NewNamespace).Create(context.TODO(), newSvc, metav1.CreateOptions{}); err != nil {
+		log.Printf("Create service = %s, namespace = %s err %s\n", name, s.NewNamespace, err)
+		return err
+	}
+	log.Printf("Create service = %s, namespace = %s complete, headless = %t.\n", name, s.NewNamespace, newSvc.Spec.ClusterIP == corev1.ClusterIPNone)
+	return nil
+}
+
+// DeleteNew delete statefulset in NewNamespace after a success dry-run
+func (s *StatefulSetSpec) DeleteNew() bool {
+	err := s.Client.AppsV1().StatefulSets(s.NewNamespace).Delete(context.TODO(), s.Name, metav1.DeleteOptions{
+		DryRun: []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		log.Printf("Dryrun delete statefulset = %s, namespace = %s err: %s\n", s.Name, s.NewNamespace, err)
+		return false
+	}
+	log.Printf("Dryrun delete statefulset = %s, namespace = %s successfully.\n", s.Name, s.NewNamespace)
+
+	var graceTimeout int64 = 40
+	_ = s.Client.AppsV1().StatefulSets(s.NewNamespace).Delete(context.TODO(), s.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &graceTimeout,
+	})
+	log.Printf("Delete statefulset = %s, namespace = %s successfully.\n", s.Name, s.NewNamespace)
+
+	return true
+}
+
+// deletePVCs delete every pvc created from volumeClaimTemplates of the old copy, name is <template>-<sts>-<ordinal>,
+// pvc left by an earlier scale down are deleted too, and wait for them gone
+func (s *StatefulSetSpec) deletePVCs(old *appsv1.StatefulSet) error {
+	pvcs, err := s.Client.CoreV1().PersistentVolumeClaims(s.NewNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Printf("List pvc in namespace = %s err: %v", s.NewNamespace, err)
+		return err
+	}
+
+	var deleted []string
+	for _, pvc := range pvcs.Items {
+		if !claimOf(pvc.Name, old) {
+			continue
+		}
+		err := s.Client.CoreV1().PersistentVolumeClaims(s.NewNamespace).Delete(context.TODO(), pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Printf("Delete pvc = %s, namespace = %s err: %v", pvc.Name, s.NewNamespace, err)
+			return err
+		}
+		log.Printf("Delete pvc = %s, namespace = %s successfully.", pvc.Name, s.NewNamespace)
+		deleted = append(deleted, pvc.Name)
+	}
+
+	return wait(fmt.Sprintf("pvc of statefulset = %s", old.Name), func() (bool, error) {
+		for _, name := range deleted {
+			_, err := s.Client.CoreV1().PersistentVolumeClaims(s.NewNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err == nil {
+				return false, nil
+			}
+			if !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// claimOf report whether pvc name is <template>-<sts>-<ordinal> of a volumeClaimTemplate of sts
+func claimOf(name string, sts *appsv1.StatefulSet) bool {
+	for _, tpl := range sts.Spec.VolumeClaimTemplates {
+		ordinal, ok := strings.CutPrefix(name, tpl.Name+"-"+sts.Name+"-")
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(ordinal); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// waitGone wait for the deleted statefulset and its pods terminated
+func (s *StatefulSetSpec) waitGone(old *appsv1.StatefulSet) error {
+	selector, err := metav1.LabelSelectorAsSelector(old.Spec.Selector)
+	if err != nil {
+		return err
+	}
+
+	return wait(fmt.Sprintf("statefulset = %s", old.Name), func() (bool, error) {
+		_, err := s.Client.AppsV1().StatefulSets(s.NewNamespace).Get(context.TODO(), old.Name, metav1.GetOptions{})
+		if err == nil {
+			return false, nil
+		}
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		pods, err := s.Client.CoreV1().Pods(s.NewNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+}
+
+// wait poll gone every second until it return true, at most 300 seconds
+func wait(what string, gone func() (bool, error)) error {
+	timeout := 300
+	log.Printf("等待 %s 删除", what)
+	for i := 0; ; i++ {
+		ok, err := gone()
+		if err != nil {
+			return err
+		}
+		if ok {
+			fmt.Println()
+			return nil
+		}
+		if i >= timeout {
+			return fmt.Errorf("等待 %d 秒 %s 仍未删除, 请检查", timeout, what)
+		}
+		if i%3 == 0 {
+			fmt.Printf(".")
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,41 @@
+package statefulset
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClaimOf(t *testing.T) {
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: appsv1.StatefulSetSpec{
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "logs"}},
+			},
+		},
+	}
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"data-web-0", true},
+		{"logs-web-1", true},
+		// left by an earlier scale down
+		{"data-web-7", true},
+		{"data-web", false},
+		{"data-web-x", false},
+		{"data-webapp-0", false},
+		{"data-other-0", false},
+		{"cache-web-0", false},
+	}
+	for _, c := range cases {
+		if got := claimOf(c.name, sts); got != c.want {
+			t.Errorf("claimOf(%q) = %t, want %t", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package statefulset
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func WaitStatefulSetUpdate(cs *kubernetes.Clientset, ns, name string, timeSecond int) error {
+	for i := 0; i <= timeSecond; i++ {
+		time.Sleep(time.Second)
+		if i%10 == 0 {
+			sts, err := cs.AppsV1().StatefulSets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				log.Printf("Wait for update cann't get statefulset, err: %v", err)
+				return err
+			}
+			fmt.Printf(".")
+			replicas := int32(1)
+			if sts.Spec.Replicas != nil {
+				replicas = *sts.Spec.Replicas
+			}
+			if sts.Status.ReadyReplicas == replicas && sts.Status.UpdatedReplicas == replicas {
+				fmt.Println()
+				log.Printf("成功更新 statefulset = %s\n", name)
+				break
+			}
+		}
+
+		if i == timeSecond {
+			return fmt.Errorf("等待 %d 秒 StatefulSet = %s 没有更新成功，程序退出!!", timeSecond, name)
+		}
+	}
+	return nil
+}
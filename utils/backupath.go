@@ -4,13 +4,43 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	"k8s.io/client-go/util/homedir"
 )
 
+// StateDir is where k8sctl keep backups, the backup index and the audit log,
+// set by --state-dir or $K8SCTL_HOME, default ~/.kube/k8sctl-backups
+func StateDir() string {
+	if dir := os.Getenv("K8SCTL_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(homedir.HomeDir(), ".kube", "k8sctl-backups")
+}
+
+// StateDirSet report whether --state-dir or $K8SCTL_HOME is given, it take precedence over backup.storage.dir
+func StateDirSet() bool {
+	return os.Getenv("K8SCTL_HOME") != ""
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ClusterDir is <cluster>/<context> of the current kubeconfig context, so every cluster and context get its own backups
+func ClusterDir() string {
+	segment := func(s string) string {
+		s = unsafePathChars.ReplaceAllString(s, "_")
+		if s == "" || s == "." || s == ".." {
+			return "default"
+		}
+		return s
+	}
+	cluster, _ := CurrentCluster()
+	return filepath.Join(segment(cluster), segment(CurrentContext()))
+}
+
 func GetBackupPath() (*string, error) {
 
-	backupPath := filepath.Join(homedir.HomeDir(), ".kube", "k8sctl-backups")
+	backupPath := filepath.Join(StateDir(), ClusterDir())
 
 	_, err := os.Lstat(backupPath)
 	if err != nil {
@@ -0,0 +1,13 @@
+package utils
+
+import "strings"
+
+// ReplaceImageTag replace the tag of image, image without tag is returned unchanged
+func ReplaceImageTag(image, tag string) string {
+	s := strings.Split(image, ":")
+	if len(s) == 2 {
+		s[1] = tag
+		return strings.Join(s, ":")
+	}
+	return image
+}
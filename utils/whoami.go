@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"os"
+	"os/user"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// CurrentUser is the ci user if run in pipeline, or the login user
+func CurrentUser() string {
+	for _, env := range []string{"GITLAB_USER_LOGIN", "USER"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// CurrentContext is the current context of kubeconfig, honor $KUBECONFIG
+func CurrentContext() string {
+	c, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		return ""
+	}
+	return c.CurrentContext
+}
+
+// CurrentCluster is the cluster name and api server of the current context of kubeconfig
+func CurrentCluster() (string, string) {
+	c, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		return "", ""
+	}
+	ctx, ok := c.Contexts[c.CurrentContext]
+	if !ok {
+		return "", ""
+	}
+	cluster, ok := c.Clusters[ctx.Cluster]
+	if !ok {
+		return ctx.Cluster, ""
+	}
+	return ctx.Cluster, cluster.Server
+}